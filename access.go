@@ -0,0 +1,586 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var accessMode = flag.String("access-mode", "open", "Who can use the AI: open (everyone except the blocklist) or allowlist (allowlisted and activated users only)")
+var premiumModel = flag.String("premium-model", "", "Default AI model of the premium tier, defaults to $PREMIUM_MODEL or the normal AI model")
+
+// Access modes.
+const (
+	AccessOpen      = "open"
+	AccessAllowlist = "allowlist"
+)
+
+// Access lists.
+const (
+	ListAllow = "allow"
+	ListBlock = "block"
+)
+
+// Built-in tiers.
+const (
+	TierFree    = "free"
+	TierPremium = "premium"
+)
+
+// Errors returned by AccessPolicy.Check for users who can't use the AI.
+var (
+	ErrBlocked      = errors.New("user is blocked")
+	ErrNotActivated = errors.New("user hasn't been activated")
+)
+
+// accessPolicy decides who can use the AI and with which plan.
+var accessPolicy *AccessPolicy
+
+// Tier is a plan with its own AI model and limits. Zero limits are unlimited.
+type Tier struct {
+	Name          string
+	Model         string
+	DailyTokens   int
+	MonthlyTokens int
+	DailyImages   int
+}
+
+// TokenLimits returns the token quota of the tier.
+func (tier *Tier) TokenLimits() QuotaLimits {
+	return QuotaLimits{Daily: tier.DailyTokens, Monthly: tier.MonthlyTokens}
+}
+
+// builtinTier returns the default settings of the free and premium tiers, which can be overridden
+// in the database.
+func builtinTier(name string) *Tier {
+	switch name {
+	case TierFree:
+		return &Tier{Name: TierFree, DailyTokens: *dailyTokenQuota, MonthlyTokens: *monthlyTokenQuota, DailyImages: *imageQuota}
+	case TierPremium:
+		return &Tier{
+			Name:          TierPremium,
+			Model:         envOr(*premiumModel, "PREMIUM_MODEL", ""),
+			MonthlyTokens: *monthlyTokenQuota * 10,
+			DailyImages:   *imageQuota * 10,
+		}
+	}
+	return nil
+}
+
+// AccessRule is an allowlist or blocklist entry. Patterns are phone numbers, or country/number prefixes
+// ending with *, e.g. 62*.
+type AccessRule struct {
+	Pattern string
+	List    string
+}
+
+func (rule *AccessRule) matches(user types.JID) bool {
+	if strings.HasSuffix(rule.Pattern, "*") {
+		return strings.HasPrefix(user.User, strings.TrimSuffix(rule.Pattern, "*"))
+	}
+	return user.User == rule.Pattern
+}
+
+// more specific rules (exact numbers, then longer prefixes) win over less specific ones.
+func (rule *AccessRule) specificity() int {
+	if strings.HasSuffix(rule.Pattern, "*") {
+		return len(rule.Pattern) - 1
+	}
+	return 1000
+}
+
+// normalizeAccessPattern turns a phone number, JID or prefix into a pattern, or returns false if it's invalid.
+func normalizeAccessPattern(input string) (string, bool) {
+	input = strings.TrimPrefix(strings.TrimSpace(input), "+")
+	input, _, _ = strings.Cut(input, "@")
+	prefix, wildcard := strings.TrimSuffix(input, "*"), strings.HasSuffix(input, "*")
+	if prefix == "" || strings.Trim(prefix, "0123456789") != "" {
+		return "", false
+	} else if wildcard {
+		return prefix + "*", true
+	}
+	return prefix, true
+}
+
+// AccessPolicy stores access lists, tiers, user plans and invite codes in the database.
+type AccessPolicy struct {
+	db *sql.DB
+}
+
+var createAccessTablesQueries = []string{`
+CREATE TABLE IF NOT EXISTS meow_access_rules (
+	pattern TEXT PRIMARY KEY,
+	list    TEXT NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS meow_tiers (
+	name           TEXT    PRIMARY KEY,
+	model          TEXT    NOT NULL,
+	daily_tokens   INTEGER NOT NULL,
+	monthly_tokens INTEGER NOT NULL,
+	daily_images   INTEGER NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS meow_users (
+	user_jid TEXT   PRIMARY KEY,
+	tier     TEXT   NOT NULL,
+	expires  BIGINT NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS meow_invite_codes (
+	code      TEXT    PRIMARY KEY,
+	tier      TEXT    NOT NULL,
+	uses_left INTEGER NOT NULL,
+	days      INTEGER NOT NULL
+)`}
+
+// NewAccessPolicy wraps an existing database connection and creates the access tables if necessary.
+func NewAccessPolicy(db *sql.DB) (*AccessPolicy, error) {
+	for _, query := range createAccessTablesQueries {
+		_, err := db.Exec(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create access tables: %w", err)
+		}
+	}
+	return &AccessPolicy{db: db}, nil
+}
+
+// Rules returns all allowlist and blocklist entries.
+func (ap *AccessPolicy) Rules() ([]AccessRule, error) {
+	rows, err := ap.db.Query(`SELECT pattern, list FROM meow_access_rules ORDER BY list, pattern`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []AccessRule
+	for rows.Next() {
+		var rule AccessRule
+		if err = rows.Scan(&rule.Pattern, &rule.List); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// SetRule adds a pattern to the allowlist or blocklist, replacing any existing entry for the same pattern.
+func (ap *AccessPolicy) SetRule(pattern, list string) error {
+	_, err := ap.db.Exec(`
+		INSERT INTO meow_access_rules (pattern, list) VALUES ($1, $2)
+		ON CONFLICT (pattern) DO UPDATE SET list=excluded.list
+	`, pattern, list)
+	return err
+}
+
+// RemoveRule removes a pattern from the access lists.
+func (ap *AccessPolicy) RemoveRule(pattern string) error {
+	_, err := ap.db.Exec(`DELETE FROM meow_access_rules WHERE pattern=$1`, pattern)
+	return err
+}
+
+// Tier returns the settings of a tier, or nil if it doesn't exist.
+func (ap *AccessPolicy) Tier(name string) (*Tier, error) {
+	tier := Tier{Name: name}
+	err := ap.db.QueryRow(
+		`SELECT model, daily_tokens, monthly_tokens, daily_images FROM meow_tiers WHERE name=$1`, name,
+	).Scan(&tier.Model, &tier.DailyTokens, &tier.MonthlyTokens, &tier.DailyImages)
+	if errors.Is(err, sql.ErrNoRows) {
+		return builtinTier(name), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &tier, nil
+}
+
+// Tiers returns the built-in tiers and all tiers defined in the database.
+func (ap *AccessPolicy) Tiers() ([]*Tier, error) {
+	rows, err := ap.db.Query(`SELECT name FROM meow_tiers WHERE name NOT IN ($1, $2) ORDER BY name`, TierFree, TierPremium)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{TierFree, TierPremium}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	_ = rows.Close()
+	tiers := make([]*Tier, len(names))
+	for i, name := range names {
+		if tiers[i], err = ap.Tier(name); err != nil {
+			return nil, err
+		}
+	}
+	return tiers, nil
+}
+
+// SetTier creates or updates a tier.
+func (ap *AccessPolicy) SetTier(tier *Tier) error {
+	_, err := ap.db.Exec(`
+		INSERT INTO meow_tiers (name, model, daily_tokens, monthly_tokens, daily_images) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name) DO UPDATE SET model=excluded.model, daily_tokens=excluded.daily_tokens,
+			monthly_tokens=excluded.monthly_tokens, daily_images=excluded.daily_images
+	`, tier.Name, tier.Model, tier.DailyTokens, tier.MonthlyTokens, tier.DailyImages)
+	return err
+}
+
+// UserPlan returns the tier name and expiry of a user's plan. Activated is false if the user has never been
+// given a plan. The returned tier is the free tier if the plan has expired.
+func (ap *AccessPolicy) UserPlan(user types.JID) (tier string, expires time.Time, activated bool, err error) {
+	var expiresUnix int64
+	err = ap.db.QueryRow(`SELECT tier, expires FROM meow_users WHERE user_jid=$1`, user.ToNonAD().String()).Scan(&tier, &expiresUnix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TierFree, time.Time{}, false, nil
+	} else if err != nil {
+		return "", time.Time{}, false, err
+	}
+	if expiresUnix > 0 {
+		expires = time.Unix(expiresUnix, 0)
+		if time.Now().After(expires) {
+			tier = TierFree
+		}
+	}
+	return tier, expires, true, nil
+}
+
+// SetUserPlan gives a user a tier. A zero expiry time never expires.
+func (ap *AccessPolicy) SetUserPlan(user types.JID, tier string, expires time.Time) error {
+	var expiresUnix int64
+	if !expires.IsZero() {
+		expiresUnix = expires.Unix()
+	}
+	_, err := ap.db.Exec(`
+		INSERT INTO meow_users (user_jid, tier, expires) VALUES ($1, $2, $3)
+		ON CONFLICT (user_jid) DO UPDATE SET tier=excluded.tier, expires=excluded.expires
+	`, user.ToNonAD().String(), tier, expiresUnix)
+	return err
+}
+
+// CreateInvite creates an invite code that activates the given tier for the given number of days (0 for forever).
+func (ap *AccessPolicy) CreateInvite(tier string, uses, days int) (string, error) {
+	code := strings.ToUpper(randomToken()[:10])
+	_, err := ap.db.Exec(`INSERT INTO meow_invite_codes (code, tier, uses_left, days) VALUES ($1, $2, $3, $4)`, code, tier, uses, days)
+	return code, err
+}
+
+// ErrInvalidInvite is returned by RedeemInvite for unknown or used up codes.
+var ErrInvalidInvite = errors.New("invalid invite code")
+
+// RedeemInvite activates the user with the tier of an invite code.
+func (ap *AccessPolicy) RedeemInvite(user types.JID, code string) (string, time.Time, error) {
+	tx, err := ap.db.Begin()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer tx.Rollback()
+	var tier string
+	var days int
+	err = tx.QueryRow(`SELECT tier, days FROM meow_invite_codes WHERE code=$1 AND uses_left > 0`, strings.ToUpper(code)).Scan(&tier, &days)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", time.Time{}, ErrInvalidInvite
+	} else if err != nil {
+		return "", time.Time{}, err
+	}
+	_, err = tx.Exec(`UPDATE meow_invite_codes SET uses_left=uses_left-1 WHERE code=$1`, strings.ToUpper(code))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var expires time.Time
+	var expiresUnix int64
+	if days > 0 {
+		expires = time.Now().AddDate(0, 0, days)
+		expiresUnix = expires.Unix()
+	}
+	_, err = tx.Exec(`
+		INSERT INTO meow_users (user_jid, tier, expires) VALUES ($1, $2, $3)
+		ON CONFLICT (user_jid) DO UPDATE SET tier=excluded.tier, expires=excluded.expires
+	`, user.ToNonAD().String(), tier, expiresUnix)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return tier, expires, tx.Commit()
+}
+
+// Check returns the tier of the user, or ErrBlocked or ErrNotActivated if the user can't use the AI.
+func (ap *AccessPolicy) Check(user types.JID) (*Tier, error) {
+	rules, err := ap.Rules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access rules: %w", err)
+	}
+	var match *AccessRule
+	for i, rule := range rules {
+		if rule.matches(user) && (match == nil || rule.specificity() > match.specificity()) {
+			match = &rules[i]
+		}
+	}
+	if match != nil && match.List == ListBlock {
+		return nil, ErrBlocked
+	}
+	tierName, _, activated, err := ap.UserPlan(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	} else if *accessMode == AccessAllowlist && match == nil && !activated {
+		return nil, ErrNotActivated
+	}
+	tier, err := ap.Tier(tierName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tier: %w", err)
+	} else if tier == nil {
+		log.Warnf("User %s has unknown tier %q, using the free tier", user, tierName)
+		tier, err = ap.Tier(TierFree)
+	}
+	return tier, err
+}
+
+// userTier returns the tier of a user for limits, falling back to the free tier if it can't be checked.
+func userTier(user types.JID) *Tier {
+	tier, err := accessPolicy.Check(user)
+	if err != nil {
+		if !errors.Is(err, ErrBlocked) && !errors.Is(err, ErrNotActivated) {
+			log.Warnf("Failed to check access of %s: %v", user, err)
+		}
+		return builtinTier(TierFree)
+	}
+	return tier
+}
+
+// checkAccess returns ErrBlocked or ErrNotActivated if the sender of the message can't use the AI.
+// Handlers call it before downloading any media, so that it isn't done for users who won't get an
+// answer anyway. Other errors are only logged, as UsageLimiter falls back to the free tier for them.
+func checkAccess(evt *events.Message) error {
+	if evt.Info.IsFromMe || isOwner(evt.Info.Sender) {
+		return nil
+	}
+	_, err := accessPolicy.Check(evt.Info.Sender)
+	if errors.Is(err, ErrBlocked) || errors.Is(err, ErrNotActivated) {
+		return err
+	} else if err != nil {
+		log.Warnf("Failed to check access of %s: %v", evt.Info.Sender, err)
+	}
+	return nil
+}
+
+func formatExpiry(expires time.Time) string {
+	if expires.IsZero() {
+		return "selamanya"
+	}
+	return "sampai " + expires.Format("02/01/2006 15:04")
+}
+
+func formatTier(tier *Tier) string {
+	model := tier.Model
+	if model == "" {
+		model = "bawaan"
+	}
+	return fmt.Sprintf("*%s*: model %s, token %s/hari, %s/bulan, gambar %s/hari", tier.Name, model,
+		formatQuota(tier.DailyTokens), formatQuota(tier.MonthlyTokens), formatQuota(tier.DailyImages))
+}
+
+func formatQuota(limit int) string {
+	if limit == 0 {
+		return "∞"
+	}
+	return fmt.Sprint(limit)
+}
+
+func init() {
+	registerCommand(&Command{
+		Name: "access",
+		Args: []CommandArg{
+			{Name: "allow/block/remove/list", Type: ArgString},
+			{Name: "nomor/prefix*", Type: ArgString, Optional: true},
+		},
+		Help:  "Kelola allowlist dan blocklist berdasarkan nomor atau awalan nomor, misalnya 62*.",
+		Level: LevelOwner,
+		Handler: func(ctx *CommandContext) {
+			action := strings.ToLower(ctx.String("allow/block/remove/list"))
+			if action == "list" {
+				rules, err := accessPolicy.Rules()
+				if err != nil {
+					log.Errorf("Failed to get access rules: %v", err)
+					return
+				}
+				var reply strings.Builder
+				fmt.Fprintf(&reply, "*Akses (%s)*\n", *accessMode)
+				for _, rule := range rules {
+					fmt.Fprintf(&reply, "\n%s %s", rule.List, rule.Pattern)
+				}
+				if len(rules) == 0 {
+					reply.WriteString("\nBelum ada aturan.")
+				}
+				ctx.Reply(reply.String())
+				return
+			}
+			pattern, ok := normalizeAccessPattern(ctx.String("nomor/prefix*"))
+			if !ok || (action != ListAllow && action != ListBlock && action != "remove") {
+				ctx.Reply("Cara pakai: %s", ctx.Command.Usage())
+				return
+			}
+			var err error
+			if action == "remove" {
+				err = accessPolicy.RemoveRule(pattern)
+			} else {
+				err = accessPolicy.SetRule(pattern, action)
+			}
+			if err != nil {
+				log.Errorf("Failed to update access rule %s: %v", pattern, err)
+				return
+			}
+			ctx.Reply("Aturan akses untuk %s diperbarui.", pattern)
+		},
+	})
+	registerCommand(&Command{
+		Name: "tier",
+		Args: []CommandArg{
+			{Name: "nama", Type: ArgString, Optional: true},
+			{Name: "model", Type: ArgString, Optional: true},
+			{Name: "token-harian", Type: ArgInt, Optional: true},
+			{Name: "token-bulanan", Type: ArgInt, Optional: true},
+			{Name: "gambar-harian", Type: ArgInt, Optional: true},
+		},
+		Help:  "Lihat daftar paket, atau buat/ubah paket (model - untuk model bawaan, batas 0 tanpa batas).",
+		Level: LevelOwner,
+		Handler: func(ctx *CommandContext) {
+			if ctx.Has("model") {
+				tier := &Tier{
+					Name:          strings.ToLower(ctx.String("nama")),
+					Model:         strings.TrimPrefix(ctx.String("model"), "-"),
+					DailyTokens:   ctx.Int("token-harian"),
+					MonthlyTokens: ctx.Int("token-bulanan"),
+					DailyImages:   ctx.Int("gambar-harian"),
+				}
+				if err := accessPolicy.SetTier(tier); err != nil {
+					log.Errorf("Failed to save tier %s: %v", tier.Name, err)
+					return
+				}
+			}
+			tiers, err := accessPolicy.Tiers()
+			if err != nil {
+				log.Errorf("Failed to get tiers: %v", err)
+				return
+			}
+			var reply strings.Builder
+			reply.WriteString("*Daftar paket*\n")
+			for _, tier := range tiers {
+				fmt.Fprintf(&reply, "\n%s", formatTier(tier))
+			}
+			ctx.Reply(reply.String())
+		},
+	})
+	registerCommand(&Command{
+		Name: "plan",
+		Args: []CommandArg{
+			{Name: "nomor", Type: ArgJID},
+			{Name: "paket", Type: ArgString},
+			{Name: "hari", Type: ArgInt, Optional: true},
+		},
+		Help:  "Berikan paket ke pengguna, selamanya atau selama beberapa hari.",
+		Level: LevelOwner,
+		Handler: func(ctx *CommandContext) {
+			user, name := ctx.JID("nomor"), strings.ToLower(ctx.String("paket"))
+			if tier, err := accessPolicy.Tier(name); err != nil {
+				log.Errorf("Failed to get tier %s: %v", name, err)
+				return
+			} else if tier == nil {
+				ctx.Reply("Paket %s tidak ada. Lihat daftarnya dengan %s.", name, commands.commands["tier"].Usage())
+				return
+			}
+			var expires time.Time
+			if days := ctx.Int("hari"); days > 0 {
+				expires = time.Now().AddDate(0, 0, days)
+			}
+			if err := accessPolicy.SetUserPlan(user, name, expires); err != nil {
+				log.Errorf("Failed to set plan of %s: %v", user, err)
+				return
+			}
+			ctx.Reply("%s sekarang memakai paket %s %s.", user.User, name, formatExpiry(expires))
+		},
+	})
+	registerCommand(&Command{
+		Name: "invite",
+		Args: []CommandArg{
+			{Name: "paket", Type: ArgString, Optional: true},
+			{Name: "jumlah", Type: ArgInt, Optional: true},
+			{Name: "hari", Type: ArgInt, Optional: true},
+		},
+		Help:  "Buat kode undangan untuk mengaktifkan paket (bawaan: free, 1 kali pakai, selamanya).",
+		Level: LevelOwner,
+		Handler: func(ctx *CommandContext) {
+			name, uses := TierFree, 1
+			if ctx.Has("paket") {
+				name = strings.ToLower(ctx.String("paket"))
+			}
+			if ctx.Has("jumlah") {
+				uses = ctx.Int("jumlah")
+			}
+			if tier, err := accessPolicy.Tier(name); err != nil || tier == nil {
+				ctx.Reply("Paket %s tidak ada.", name)
+				return
+			}
+			code, err := accessPolicy.CreateInvite(name, uses, ctx.Int("hari"))
+			if err != nil {
+				log.Errorf("Failed to create invite code: %v", err)
+				return
+			}
+			ctx.Reply("Kode undangan paket %s (%d kali pakai): %s", name, uses, code)
+		},
+	})
+	registerCommand(&Command{
+		Name:     "activate",
+		Aliases:  []string{"aktivasi"},
+		Args:     []CommandArg{{Name: "kode", Type: ArgString}},
+		Help:     "Aktifkan akses bot dengan kode undangan.",
+		Cooldown: 10 * time.Second,
+		Handler: func(ctx *CommandContext) {
+			user := ctx.Event.Info.Sender
+			tier, expires, err := accessPolicy.RedeemInvite(user, ctx.String("kode"))
+			if errors.Is(err, ErrInvalidInvite) {
+				ctx.Reply("Kode undangan tidak valid atau sudah habis dipakai.")
+				return
+			} else if err != nil {
+				log.Errorf("Failed to redeem invite code for %s: %v", user, err)
+				return
+			}
+			ctx.Reply("Aktivasi berhasil 🎉. Kamu memakai paket %s %s.", tier, formatExpiry(expires))
+		},
+	})
+	registerCommand(&Command{
+		Name: "paket",
+		Help: "Lihat paket dan sisa jatah pemakaian AI kamu.",
+		Handler: func(ctx *CommandContext) {
+			user := ctx.Event.Info.Sender
+			name, expires, _, err := accessPolicy.UserPlan(user)
+			if err != nil {
+				log.Errorf("Failed to get plan of %s: %v", user, err)
+				return
+			}
+			tier := userTier(user)
+			limits, err := quotas.Limits(user, FeatureTokens, tier.TokenLimits())
+			if err != nil {
+				log.Errorf("Failed to get quota of %s: %v", user, err)
+				return
+			}
+			daily, monthly, err := quotas.Usage(user, FeatureTokens)
+			if err != nil {
+				log.Errorf("Failed to get AI usage of %s: %v", user, err)
+				return
+			}
+			images, _, err := quotas.Usage(user, FeatureImage)
+			if err != nil {
+				log.Errorf("Failed to get image usage of %s: %v", user, err)
+				return
+			}
+			if name != TierFree {
+				name += " " + formatExpiry(expires)
+			}
+			ctx.Reply("*Paket %s*\n\nToken hari ini: %s\nToken bulan ini: %s\nGambar hari ini: %s", name,
+				formatLimit(daily, limits.Daily), formatLimit(monthly, limits.Monthly), formatLimit(images, tier.DailyImages))
+		},
+	})
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+var apiToken = flag.String("api-token", "", "Access token for the REST API, defaults to $API_TOKEN (the API is disabled without a token)")
+
+const maxAPIBodySize = 32 * 1024 * 1024
+
+// apiErrorResponse is the body of failed API responses.
+type apiErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// apiSendResponse is returned by all API endpoints that send a message.
+type apiSendResponse struct {
+	ID        types.MessageID `json:"id"`
+	Timestamp int64           `json:"timestamp"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, &apiErrorResponse{Error: fmt.Sprintf(format, args...)})
+}
+
+// apiHandler wraps an API endpoint with token authentication and method checking.
+func apiHandler(method string, fn func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !tokenMatches(requestToken(r, ""), envOr(*apiToken, "API_TOKEN", "")) {
+			writeAPIError(w, http.StatusUnauthorized, "invalid or missing access token")
+			return
+		} else if r.Method != method {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method must be %s", method)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func readAPIRequest(w http.ResponseWriter, r *http.Request, into interface{}) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize)).Decode(into)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return false
+	}
+	return true
+}
+
+// apiJID parses a JID in a request. Errors are written to the response.
+func apiJID(w http.ResponseWriter, field, value string) (types.JID, bool) {
+	if value == "" {
+		writeAPIError(w, http.StatusBadRequest, "%s is required", field)
+		return types.EmptyJID, false
+	}
+	jid, ok := parseJID(value)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid %s", field)
+	}
+	return jid, ok
+}
+
+func apiSend(w http.ResponseWriter, to types.JID, msg *waProto.Message) {
+	resp, err := sendMessage(to, msg)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "failed to send message: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &apiSendResponse{ID: resp.ID, Timestamp: resp.Timestamp.Unix()})
+}
+
+type apiSendTextRequest struct {
+	To   string `json:"to"`
+	Text string `json:"text"`
+}
+
+func apiSendText(w http.ResponseWriter, r *http.Request) {
+	var req apiSendTextRequest
+	if !readAPIRequest(w, r, &req) {
+		return
+	}
+	to, ok := apiJID(w, "to", req.To)
+	if !ok {
+		return
+	} else if req.Text == "" {
+		writeAPIError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+	apiSend(w, to, &waProto.Message{Conversation: proto.String(req.Text)})
+}
+
+type apiSendImageRequest struct {
+	To       string `json:"to"`
+	Image    string `json:"image"`
+	Mimetype string `json:"mimetype,omitempty"`
+	Caption  string `json:"caption,omitempty"`
+}
+
+func apiSendImage(w http.ResponseWriter, r *http.Request) {
+	var req apiSendImageRequest
+	if !readAPIRequest(w, r, &req) {
+		return
+	}
+	to, ok := apiJID(w, "to", req.To)
+	if !ok {
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(req.Image)
+	if err != nil || len(data) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "image must be base64-encoded image data")
+		return
+	}
+	if req.Mimetype == "" {
+		req.Mimetype = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(req.Mimetype, "image/") {
+		writeAPIError(w, http.StatusBadRequest, "unsupported image type %s", req.Mimetype)
+		return
+	}
+	msg, err := buildMediaMessage(data, whatsmeow.MediaImage, req.Mimetype, req.Caption, nil)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "%v", err)
+		return
+	}
+	apiSend(w, to, msg)
+}
+
+type apiSendPollRequest struct {
+	To         string   `json:"to"`
+	Question   string   `json:"question"`
+	Options    []string `json:"options"`
+	MaxAnswers int      `json:"max_answers,omitempty"`
+}
+
+func apiSendPoll(w http.ResponseWriter, r *http.Request) {
+	var req apiSendPollRequest
+	if !readAPIRequest(w, r, &req) {
+		return
+	}
+	to, ok := apiJID(w, "to", req.To)
+	if !ok {
+		return
+	} else if req.Question == "" || len(req.Options) < 2 {
+		writeAPIError(w, http.StatusBadRequest, "question and at least two options are required")
+		return
+	}
+	if req.MaxAnswers <= 0 {
+		req.MaxAnswers = 1
+	}
+	apiSend(w, to, cli.BuildPollCreation(req.Question, req.Options, req.MaxAnswers))
+}
+
+type apiReactRequest struct {
+	Chat      string `json:"chat"`
+	MessageID string `json:"message_id"`
+	// Sender is required when reacting to someone else's message in a group.
+	Sender   string `json:"sender,omitempty"`
+	FromMe   bool   `json:"from_me,omitempty"`
+	Reaction string `json:"reaction"`
+}
+
+func apiReact(w http.ResponseWriter, r *http.Request) {
+	var req apiReactRequest
+	if !readAPIRequest(w, r, &req) {
+		return
+	}
+	chat, ok := apiJID(w, "chat", req.Chat)
+	if !ok {
+		return
+	} else if req.MessageID == "" {
+		writeAPIError(w, http.StatusBadRequest, "message_id is required")
+		return
+	}
+	key := &waProto.MessageKey{
+		RemoteJID: proto.String(chat.String()),
+		FromMe:    proto.Bool(req.FromMe),
+		ID:        proto.String(req.MessageID),
+	}
+	if req.Sender != "" {
+		sender, ok := apiJID(w, "sender", req.Sender)
+		if !ok {
+			return
+		}
+		key.Participant = proto.String(sender.String())
+	}
+	apiSend(w, chat, &waProto.Message{ReactionMessage: &waProto.ReactionMessage{
+		Key:               key,
+		Text:              proto.String(req.Reaction),
+		SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
+	}})
+}
+
+type apiRevokeRequest struct {
+	Chat      string `json:"chat"`
+	MessageID string `json:"message_id"`
+	// Sender is only needed for revoking other users' messages as a group admin.
+	Sender string `json:"sender,omitempty"`
+}
+
+func apiRevoke(w http.ResponseWriter, r *http.Request) {
+	var req apiRevokeRequest
+	if !readAPIRequest(w, r, &req) {
+		return
+	}
+	chat, ok := apiJID(w, "chat", req.Chat)
+	if !ok {
+		return
+	} else if req.MessageID == "" {
+		writeAPIError(w, http.StatusBadRequest, "message_id is required")
+		return
+	}
+	sender := types.EmptyJID
+	if req.Sender != "" {
+		if sender, ok = apiJID(w, "sender", req.Sender); !ok {
+			return
+		}
+	}
+	apiSend(w, chat, cli.BuildRevoke(chat, sender, req.MessageID))
+}
+
+func apiListGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := cli.GetJoinedGroups()
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "failed to get group list: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+func apiGroupInfo(w http.ResponseWriter, r *http.Request) {
+	group, ok := apiJID(w, "jid", r.URL.Query().Get("jid"))
+	if !ok {
+		return
+	} else if group.Server != types.GroupServer {
+		writeAPIError(w, http.StatusBadRequest, "jid must be a group JID (@%s)", types.GroupServer)
+		return
+	}
+	info, err := cli.GetGroupInfo(group)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "failed to get group info: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+type apiCheckUserRequest struct {
+	Phones []string `json:"phones"`
+}
+
+func apiCheckUser(w http.ResponseWriter, r *http.Request) {
+	var req apiCheckUserRequest
+	if !readAPIRequest(w, r, &req) {
+		return
+	} else if len(req.Phones) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "phones is required")
+		return
+	}
+	for i, phone := range req.Phones {
+		if !strings.HasPrefix(phone, "+") {
+			req.Phones[i] = "+" + phone
+		}
+	}
+	resp, err := cli.IsOnWhatsApp(req.Phones)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "failed to check users: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// registerAPIHandlers adds the REST API to the HTTP server if an access token is configured.
+func registerAPIHandlers() {
+	if envOr(*apiToken, "API_TOKEN", "") == "" {
+		log.Infof("No API token configured, REST API is disabled")
+		return
+	}
+	httpMux.HandleFunc("/api/send/text", apiHandler(http.MethodPost, apiSendText))
+	httpMux.HandleFunc("/api/send/image", apiHandler(http.MethodPost, apiSendImage))
+	httpMux.HandleFunc("/api/send/poll", apiHandler(http.MethodPost, apiSendPoll))
+	httpMux.HandleFunc("/api/react", apiHandler(http.MethodPost, apiReact))
+	httpMux.HandleFunc("/api/revoke", apiHandler(http.MethodPost, apiRevoke))
+	httpMux.HandleFunc("/api/groups", apiHandler(http.MethodGet, apiListGroups))
+	httpMux.HandleFunc("/api/groups/info", apiHandler(http.MethodGet, apiGroupInfo))
+	httpMux.HandleFunc("/api/users/check", apiHandler(http.MethodPost, apiCheckUser))
+}
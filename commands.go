@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var commandPrefixes = flag.String("command-prefixes", "!/", "Characters that start a bot command")
+var ownerNumbers = flag.String("owners", "", "Comma-separated phone numbers allowed to use owner commands in addition to the bot account itself")
+
+// PermissionLevel decides who can use a command.
+type PermissionLevel int
+
+const (
+	LevelUser PermissionLevel = iota
+	LevelAdmin
+	LevelOwner
+)
+
+func (level PermissionLevel) String() string {
+	switch level {
+	case LevelOwner:
+		return "owner"
+	case LevelAdmin:
+		return "admin"
+	default:
+		return "user"
+	}
+}
+
+// ArgType is the type of a command argument.
+type ArgType int
+
+const (
+	// ArgString is a single word.
+	ArgString ArgType = iota
+	// ArgInt is a single integer.
+	ArgInt
+	// ArgJID is a phone number or a full JID.
+	ArgJID
+	// ArgText is the rest of the message. It must be the last argument.
+	ArgText
+)
+
+// CommandArg describes a single argument of a command.
+type CommandArg struct {
+	Name     string
+	Type     ArgType
+	Optional bool
+}
+
+// Command is a bot command that can be sent as a WhatsApp message.
+type Command struct {
+	Name     string
+	Aliases  []string
+	Args     []CommandArg
+	Help     string
+	Level    PermissionLevel
+	Cooldown time.Duration
+	// AnyGroup allows using the command in groups where the bot hasn't been enabled.
+	AnyGroup bool
+	Handler  func(ctx *CommandContext)
+}
+
+// Usage returns the usage string of the command, e.g. "!img <prompt>".
+func (cmd *Command) Usage() string {
+	parts := []string{string((*commandPrefixes)[0]) + cmd.Name}
+	for _, arg := range cmd.Args {
+		if arg.Optional {
+			parts = append(parts, "["+arg.Name+"]")
+		} else {
+			parts = append(parts, "<"+arg.Name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// CommandContext contains the message and parsed arguments of a command invocation.
+type CommandContext struct {
+	Event   *events.Message
+	Command *Command
+	// Raw contains the unparsed words after the command name.
+	Raw  []string
+	args map[string]interface{}
+
+	level      PermissionLevel
+	levelKnown bool
+}
+
+// Level returns the permission level of the sender. It's looked up on the first call, since checking
+// the admins of a group needs a request to the server.
+func (ctx *CommandContext) Level() PermissionLevel {
+	if !ctx.levelKnown {
+		ctx.level = permissionLevel(ctx.Event)
+		ctx.levelKnown = true
+	}
+	return ctx.level
+}
+
+// String returns the value of a string or text argument.
+func (ctx *CommandContext) String(name string) string {
+	val, _ := ctx.args[name].(string)
+	return val
+}
+
+// Int returns the value of an integer argument.
+func (ctx *CommandContext) Int(name string) int {
+	val, _ := ctx.args[name].(int)
+	return val
+}
+
+// JID returns the value of a JID argument.
+func (ctx *CommandContext) JID(name string) types.JID {
+	val, _ := ctx.args[name].(types.JID)
+	return val
+}
+
+// Has returns true if the optional argument was given.
+func (ctx *CommandContext) Has(name string) bool {
+	_, ok := ctx.args[name]
+	return ok
+}
+
+// Reply sends a text reply quoting the command message.
+func (ctx *CommandContext) Reply(format string, args ...interface{}) {
+	if len(args) > 0 {
+		format = fmt.Sprintf(format, args...)
+	}
+	replyQuote(ctx.Event, format)
+}
+
+var errUsage = errors.New("invalid usage")
+
+func (ctx *CommandContext) parseArgs(words []string) error {
+	ctx.args = make(map[string]interface{})
+	for i, arg := range ctx.Command.Args {
+		if i >= len(words) {
+			if arg.Optional {
+				return nil
+			}
+			return errUsage
+		}
+		switch arg.Type {
+		case ArgString:
+			ctx.args[arg.Name] = words[i]
+		case ArgInt:
+			val, err := strconv.Atoi(words[i])
+			if err != nil {
+				return fmt.Errorf("%s must be a number", arg.Name)
+			}
+			ctx.args[arg.Name] = val
+		case ArgJID:
+			jid, ok := parseJID(words[i])
+			if !ok {
+				return fmt.Errorf("%s must be a phone number or JID", arg.Name)
+			}
+			ctx.args[arg.Name] = jid
+		case ArgText:
+			ctx.args[arg.Name] = strings.Join(words[i:], " ")
+			return nil
+		}
+	}
+	return nil
+}
+
+// CommandRouter dispatches command messages to registered commands.
+type CommandRouter struct {
+	commands map[string]*Command
+	ordered  []*Command
+
+	cooldownLock  sync.Mutex
+	cooldownUntil map[string]time.Time
+	lastPrune     time.Time
+}
+
+// commands is the global command registry. Files register their commands in init functions.
+var commands = &CommandRouter{
+	commands:      make(map[string]*Command),
+	cooldownUntil: make(map[string]time.Time),
+}
+
+// registerCommand adds a command to the global registry.
+func registerCommand(cmd *Command) {
+	commands.Register(cmd)
+}
+
+// Register adds a command and its aliases to the router.
+func (cr *CommandRouter) Register(cmd *Command) {
+	for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+		if _, exists := cr.commands[name]; exists {
+			panic(fmt.Errorf("command %q registered twice", name))
+		}
+		cr.commands[name] = cmd
+	}
+	cr.ordered = append(cr.ordered, cmd)
+	sort.Slice(cr.ordered, func(i, j int) bool {
+		return cr.ordered[i].Name < cr.ordered[j].Name
+	})
+}
+
+// Parse checks if the text is a registered command and returns it along with the argument words.
+func (cr *CommandRouter) Parse(text string) (*Command, []string) {
+	text = strings.TrimSpace(text)
+	if len(text) < 2 || !strings.ContainsRune(*commandPrefixes, rune(text[0])) {
+		return nil, nil
+	}
+	words := strings.Fields(text[1:])
+	if len(words) == 0 {
+		return nil, nil
+	}
+	return cr.commands[strings.ToLower(words[0])], words[1:]
+}
+
+// IsCommand returns true if the text invokes a registered command.
+func (cr *CommandRouter) IsCommand(text string) bool {
+	cmd, _ := cr.Parse(text)
+	return cmd != nil
+}
+
+func isOwner(sender types.JID) bool {
+	for _, number := range strings.Split(*ownerNumbers, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(number), "+") == sender.User {
+			return true
+		}
+	}
+	return false
+}
+
+// permissionLevel returns the highest permission level of the sender of the message.
+func permissionLevel(evt *events.Message) PermissionLevel {
+	if evt.Info.IsFromMe || isOwner(evt.Info.Sender) {
+		return LevelOwner
+	} else if evt.Info.IsGroup {
+		isAdmin, err := isGroupAdmin(evt.Info.Chat, evt.Info.Sender)
+		if err != nil {
+			log.Warnf("Failed to check admins of %s: %v", evt.Info.Chat, err)
+		} else if isAdmin {
+			return LevelAdmin
+		}
+	}
+	return LevelUser
+}
+
+// onCooldown checks and updates the cooldown of the command for the sender.
+func (cr *CommandRouter) onCooldown(cmd *Command, sender types.JID) time.Duration {
+	if cmd.Cooldown == 0 {
+		return 0
+	}
+	key := cmd.Name + "|" + sender.ToNonAD().String()
+	cr.cooldownLock.Lock()
+	defer cr.cooldownLock.Unlock()
+	now := time.Now()
+	if remaining := cr.cooldownUntil[key].Sub(now); remaining > 0 {
+		return remaining
+	}
+	cr.cooldownUntil[key] = now.Add(cmd.Cooldown)
+	// Expired cooldowns are dropped once a minute, so the map doesn't keep every user who ever used a command.
+	if now.Sub(cr.lastPrune) > time.Minute {
+		for key, until := range cr.cooldownUntil {
+			if until.Before(now) {
+				delete(cr.cooldownUntil, key)
+			}
+		}
+		cr.lastPrune = now
+	}
+	return 0
+}
+
+// Run executes the command in the message, if it is one the sender is allowed to use.
+func (cr *CommandRouter) Run(evt *events.Message, text string) {
+	cmd, words := cr.Parse(text)
+	if cmd == nil {
+		return
+	}
+	if evt.Info.IsGroup && !evt.Info.IsFromMe && !cmd.AnyGroup {
+		enabled, err := groups.Enabled(evt.Info.Chat)
+		if err != nil {
+			log.Errorf("Failed to get settings of %s: %v", evt.Info.Chat, err)
+			return
+		} else if !*groupsAllowed || !enabled {
+			return
+		}
+	}
+	ctx := &CommandContext{Event: evt, Command: cmd, Raw: words}
+	if cmd.Level > LevelUser && ctx.Level() < cmd.Level {
+		ctx.Reply("Maaf, perintah ini hanya untuk %s 🙏.", cmd.Level)
+		return
+	}
+	err := ctx.parseArgs(words)
+	if errors.Is(err, errUsage) {
+		ctx.Reply("Cara pakai: %s", cmd.Usage())
+		return
+	} else if err != nil {
+		ctx.Reply("%v\nCara pakai: %s", err, cmd.Usage())
+		return
+	}
+	// The cooldown only starts once the command is actually run, so typos don't use it up.
+	if remaining := cr.onCooldown(cmd, evt.Info.Sender); remaining > 0 {
+		ctx.Reply("Tunggu %d detik lagi sebelum memakai perintah ini.", int(remaining.Seconds())+1)
+		return
+	}
+	log.Infof("Running command %s from %s", cmd.Name, evt.Info.SourceString())
+	cmd.Handler(ctx)
+}
+
+// Help returns the list of commands available at the given permission level.
+func (cr *CommandRouter) Help(level PermissionLevel) string {
+	var help strings.Builder
+	help.WriteString("*Daftar perintah*\n")
+	for _, cmd := range cr.ordered {
+		if cmd.Level > level {
+			continue
+		}
+		fmt.Fprintf(&help, "\n```%s```\n%s\n", cmd.Usage(), cmd.Help)
+	}
+	return help.String()
+}
+
+// runShellCommand runs a program and returns its output, or the error if it failed.
+func runShellCommand(name string, args ...string) string {
+	output, err := exec.Command(name, args...).Output()
+	if err != nil {
+		log.Errorf("Failed to run %s: %v", name, err)
+		if len(output) == 0 {
+			return fmt.Sprintf("Gagal menjalankan %s: %v", name, err)
+		}
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func init() {
+	registerCommand(&Command{
+		Name:    "help",
+		Aliases: []string{"menu"},
+		Help:    "Tampilkan daftar perintah.",
+		Handler: func(ctx *CommandContext) {
+			ctx.Reply(commands.Help(ctx.Level()))
+		},
+	})
+	registerCommand(&Command{
+		Name: "reset",
+		Help: "Hapus memori obrolan bot di chat ini. Di grup hanya untuk admin.",
+		Handler: func(ctx *CommandContext) {
+			if ctx.Event.Info.IsGroup && ctx.Level() < LevelAdmin {
+				ctx.Reply("Maaf, di grup perintah ini hanya untuk admin 🙏.")
+				return
+			}
+			err := history.Clear(ctx.Event.Info.Chat)
+			if err != nil {
+				log.Errorf("Failed to clear history of %s: %v", ctx.Event.Info.Chat, err)
+			}
+			ctx.Reply("Memori obrolan kita sudah dihapus, ayo mulai dari awal 🙂.\n\n-*AI Bot*")
+		},
+	})
+	registerCommand(&Command{
+		Name:     "status",
+		Help:     "Tampilkan informasi server.",
+		Level:    LevelOwner,
+		Cooldown: 10 * time.Second,
+		Handler: func(ctx *CommandContext) {
+			ctx.Reply(runShellCommand("neofetch", "--stdout"))
+		},
+	})
+	registerCommand(&Command{
+		Name:     "speedtest",
+		Help:     "Tes kecepatan internet server.",
+		Level:    LevelOwner,
+		Cooldown: time.Minute,
+		Handler: func(ctx *CommandContext) {
+			ctx.Reply(runShellCommand("speedtest", "--progress=no"))
+		},
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// bufferLogger is a waLog.Logger that collects console command output so it can be sent as a
+// WhatsApp message. Debug messages go to the main log instead.
+type bufferLogger struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+var _ waLog.Logger = (*bufferLogger)(nil)
+
+func (bl *bufferLogger) add(prefix, msg string, args []interface{}) {
+	bl.lock.Lock()
+	bl.lines = append(bl.lines, prefix+fmt.Sprintf(msg, args...))
+	bl.lock.Unlock()
+}
+
+func (bl *bufferLogger) Errorf(msg string, args ...interface{}) { bl.add("❌ ", msg, args) }
+func (bl *bufferLogger) Warnf(msg string, args ...interface{})  { bl.add("⚠️ ", msg, args) }
+func (bl *bufferLogger) Infof(msg string, args ...interface{})  { bl.add("", msg, args) }
+func (bl *bufferLogger) Debugf(msg string, args ...interface{}) { log.Debugf(msg, args...) }
+func (bl *bufferLogger) Sub(_ string) waLog.Logger              { return bl }
+
+// String returns the collected output.
+func (bl *bufferLogger) String() string {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	return strings.Join(bl.lines, "\n")
+}
+
+// consoleCommands are the stdin commands of handleCmd that the owner can also use over WhatsApp.
+var consoleCommands = []*Command{
+	{Name: "reconnect", Help: "Sambungkan ulang ke WhatsApp."},
+	{Name: "logout", Help: "Keluar dan hapus sesi bot."},
+	{Name: "appstate", Args: []CommandArg{{Name: "types...", Type: ArgText}}, Help: "Sinkronkan app state (pakai \"all\" untuk semua)."},
+	{Name: "request-appstate-key", Args: []CommandArg{{Name: "ids...", Type: ArgText}}, Help: "Minta kunci app state."},
+	{Name: "checkuser", Args: []CommandArg{{Name: "phone numbers...", Type: ArgText}}, Help: "Cek apakah nomor terdaftar di WhatsApp."},
+	{Name: "checkupdate", Help: "Cek versi klien WhatsApp."},
+	{Name: "subscribepresence", Args: []CommandArg{{Name: "jid", Type: ArgString}}, Help: "Langganan status online pengguna."},
+	{Name: "presence", Args: []CommandArg{{Name: "available/unavailable", Type: ArgString}}, Help: "Atur status online bot."},
+	{Name: "chatpresence", Args: []CommandArg{{Name: "jid", Type: ArgString}, {Name: "composing/paused", Type: ArgString}, {Name: "audio", Type: ArgString, Optional: true}}, Help: "Kirim status mengetik ke chat."},
+	{Name: "privacysettings", Help: "Tampilkan pengaturan privasi."},
+	{Name: "getuser", Args: []CommandArg{{Name: "jids...", Type: ArgText}}, Help: "Tampilkan info pengguna."},
+	{Name: "getavatar", Args: []CommandArg{{Name: "jid", Type: ArgString}, {Name: "existing ID", Type: ArgString, Optional: true}, {Name: "--preview", Type: ArgString, Optional: true}}, Help: "Tampilkan foto profil."},
+	{Name: "getgroup", Args: []CommandArg{{Name: "jid", Type: ArgString}}, Help: "Tampilkan info grup."},
+	{Name: "subgroups", Args: []CommandArg{{Name: "jid", Type: ArgString}}, Help: "Tampilkan subgrup komunitas."},
+	{Name: "communityparticipants", Args: []CommandArg{{Name: "jid", Type: ArgString}}, Help: "Tampilkan anggota komunitas."},
+	{Name: "listgroups", Help: "Tampilkan semua grup yang diikuti bot."},
+	{Name: "getinvitelink", Args: []CommandArg{{Name: "jid", Type: ArgString}, {Name: "--reset", Type: ArgString, Optional: true}}, Help: "Tampilkan link undangan grup."},
+	{Name: "queryinvitelink", Args: []CommandArg{{Name: "link", Type: ArgString}}, Help: "Tampilkan info grup dari link undangan."},
+	{Name: "querybusinesslink", Args: []CommandArg{{Name: "link", Type: ArgString}}, Help: "Tampilkan info link pesan bisnis."},
+	{Name: "joininvitelink", Args: []CommandArg{{Name: "link", Type: ArgString}}, Help: "Gabung ke grup lewat link undangan."},
+	{Name: "getstatusprivacy", Help: "Tampilkan privasi status."},
+	{Name: "setdisappeartimer", Args: []CommandArg{{Name: "jid", Type: ArgString}, {Name: "days", Type: ArgInt}}, Help: "Atur pesan sementara."},
+	{Name: "send", Args: []CommandArg{{Name: "jid", Type: ArgString}, {Name: "text", Type: ArgText}}, Help: "Kirim pesan teks."},
+	{Name: "sendpoll", Args: []CommandArg{{Name: "jid", Type: ArgString}, {Name: "max answers", Type: ArgInt}, {Name: "question -- option 1 / option 2 / ...", Type: ArgText}}, Help: "Kirim polling."},
+	{Name: "multisend", Args: []CommandArg{{Name: "jids... -- text", Type: ArgText}}, Help: "Kirim pesan ke banyak chat."},
+	{Name: "react", Args: []CommandArg{{Name: "jid", Type: ArgString}, {Name: "message ID", Type: ArgString}, {Name: "reaction", Type: ArgString}}, Help: "Kirim reaksi ke pesan."},
+	{Name: "revoke", Args: []CommandArg{{Name: "jid", Type: ArgString}, {Name: "message ID", Type: ArgString}}, Help: "Hapus pesan untuk semua orang."},
+	{Name: "sendimg", Args: []CommandArg{{Name: "jid", Type: ArgString}, {Name: "image path", Type: ArgString}, {Name: "caption", Type: ArgText, Optional: true}}, Help: "Kirim gambar dari server."},
+	{Name: "setstatus", Args: []CommandArg{{Name: "message", Type: ArgText}}, Help: "Atur info (about) bot."},
+}
+
+// runConsoleCommand runs a console command and replies with its output.
+func runConsoleCommand(ctx *CommandContext) {
+	out := &bufferLogger{}
+	handleCmd(ctx.Command.Name, ctx.Raw, out)
+	output := out.String()
+	if output == "" {
+		output = "Selesai (tidak ada output)."
+	}
+	ctx.Reply("*%s*\n```\n%s\n```", ctx.Command.Name, output)
+}
+
+func init() {
+	for _, cmd := range consoleCommands {
+		cmd.Level = LevelOwner
+		cmd.Handler = runConsoleCommand
+		registerCommand(cmd)
+	}
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var maxDocumentSize = flag.Int("max-document-size", 10, "Maximum size of documents the bot reads in megabytes")
+var documentChunkSize = flag.Int("document-chunk-size", 1000, "Approximate number of characters in each indexed part of a document")
+var documentTopK = flag.Int("document-top-k", 4, "Number of document parts included in prompts about a document")
+
+// maxDocumentText limits the text read from a single document. Compressed documents can contain far
+// more text than their file size suggests.
+const maxDocumentText = 2 * 1024 * 1024
+
+// Errors returned when a document can't be read.
+var (
+	ErrDocumentTooLarge    = errors.New("document is too large")
+	ErrUnsupportedDocument = errors.New("unsupported document type")
+	ErrEmptyDocument       = errors.New("document doesn't contain any text")
+	ErrPDFUnavailable      = errors.New("pdftotext is not installed")
+)
+
+// pdftotextPath is the path of pdftotext, empty if it isn't installed and PDFs can't be read.
+var pdftotextPath string
+
+// checkPDFSupport looks for pdftotext, which is needed to read PDF documents.
+func checkPDFSupport() {
+	var err error
+	pdftotextPath, err = exec.LookPath("pdftotext")
+	if err != nil {
+		log.Warnf("pdftotext not found, PDF documents can't be read: %v", err)
+	}
+}
+
+// Kinds of documents the bot can read, identified by file extension.
+const (
+	DocumentPDF      = ".pdf"
+	DocumentText     = ".txt"
+	DocumentMarkdown = ".md"
+	DocumentWord     = ".docx"
+)
+
+var documentMimetypes = map[string]string{
+	"application/pdf": DocumentPDF,
+	"text/plain":      DocumentText,
+	"text/markdown":   DocumentMarkdown,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": DocumentWord,
+}
+
+// documents is the per-chat document index used to answer questions about documents.
+var documents *DocumentStore
+
+// DocumentInfo describes an indexed document.
+type DocumentInfo struct {
+	MessageID types.MessageID
+	Name      string
+	Chunks    int
+	Timestamp time.Time
+}
+
+// DocumentStore keeps the text of documents sent to the bot, split into chunks for retrieval.
+type DocumentStore struct {
+	db *sql.DB
+}
+
+const createDocumentsTableQuery = `
+CREATE TABLE IF NOT EXISTS meow_documents (
+	chat       TEXT   NOT NULL,
+	message_id TEXT   NOT NULL,
+	name       TEXT   NOT NULL,
+	timestamp  BIGINT NOT NULL,
+	PRIMARY KEY (chat, message_id)
+)`
+
+const createDocumentChunksTableQuery = `
+CREATE TABLE IF NOT EXISTS meow_document_chunks (
+	chat       TEXT    NOT NULL,
+	message_id TEXT    NOT NULL,
+	idx        INTEGER NOT NULL,
+	content    TEXT    NOT NULL,
+	PRIMARY KEY (chat, message_id, idx)
+)`
+
+// NewDocumentStore wraps an existing database connection and creates the document tables if necessary.
+func NewDocumentStore(db *sql.DB) (*DocumentStore, error) {
+	for _, query := range []string{createDocumentsTableQuery, createDocumentChunksTableQuery} {
+		_, err := db.Exec(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create document tables: %w", err)
+		}
+	}
+	return &DocumentStore{db: db}, nil
+}
+
+// Add stores the chunks of a document, replacing any previous version.
+func (ds *DocumentStore) Add(chat types.JID, id types.MessageID, name string, chunks []string) error {
+	tx, err := ds.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`DELETE FROM meow_document_chunks WHERE chat=$1 AND message_id=$2`, chat.String(), id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO meow_documents (chat, message_id, name, timestamp) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (chat, message_id) DO UPDATE SET name=excluded.name, timestamp=excluded.timestamp
+	`, chat.String(), id, name, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	for i, chunk := range chunks {
+		_, err = tx.Exec(`INSERT INTO meow_document_chunks (chat, message_id, idx, content) VALUES ($1, $2, $3, $4)`, chat.String(), id, i, chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Chunks returns the chunks of a document in order, or nil if the document isn't indexed.
+func (ds *DocumentStore) Chunks(chat types.JID, id types.MessageID) ([]string, error) {
+	rows, err := ds.db.Query(`SELECT content FROM meow_document_chunks WHERE chat=$1 AND message_id=$2 ORDER BY idx`, chat.String(), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var chunks []string
+	for rows.Next() {
+		var chunk string
+		if err = rows.Scan(&chunk); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// List returns the documents indexed in a chat, oldest first.
+func (ds *DocumentStore) List(chat types.JID) ([]DocumentInfo, error) {
+	rows, err := ds.db.Query(`
+		SELECT d.message_id, d.name, d.timestamp, COUNT(c.idx) FROM meow_documents d
+		LEFT JOIN meow_document_chunks c ON c.chat=d.chat AND c.message_id=d.message_id
+		WHERE d.chat=$1 GROUP BY d.message_id, d.name, d.timestamp ORDER BY d.timestamp, d.message_id
+	`, chat.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var docs []DocumentInfo
+	for rows.Next() {
+		var doc DocumentInfo
+		var ts int64
+		if err = rows.Scan(&doc.MessageID, &doc.Name, &ts, &doc.Chunks); err != nil {
+			return nil, err
+		}
+		doc.Timestamp = time.Unix(ts, 0)
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// Forget deletes a document from the index. If id is empty, all documents of the chat are deleted.
+func (ds *DocumentStore) Forget(chat types.JID, id types.MessageID) error {
+	tx, err := ds.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"meow_document_chunks", "meow_documents"} {
+		if id == "" {
+			_, err = tx.Exec(`DELETE FROM `+table+` WHERE chat=$1`, chat.String())
+		} else {
+			_, err = tx.Exec(`DELETE FROM `+table+` WHERE chat=$1 AND message_id=$2`, chat.String(), id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// documentKind returns the kind of a document based on its file name or mimetype.
+func documentKind(doc *waProto.DocumentMessage) string {
+	ext := strings.ToLower(filepath.Ext(doc.GetFileName()))
+	switch ext {
+	case DocumentPDF, DocumentText, DocumentMarkdown, DocumentWord:
+		return ext
+	}
+	mimetype, _, _ := strings.Cut(doc.GetMimetype(), ";")
+	return documentMimetypes[strings.TrimSpace(mimetype)]
+}
+
+// extractDocumentText returns the plain text of a document.
+func extractDocumentText(kind string, data []byte) (string, error) {
+	switch kind {
+	case DocumentText, DocumentMarkdown:
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("%w: text is not valid UTF-8", ErrUnsupportedDocument)
+		}
+		return string(data), nil
+	case DocumentPDF:
+		return extractPDFText(data)
+	case DocumentWord:
+		return extractDocxText(data)
+	default:
+		return "", ErrUnsupportedDocument
+	}
+}
+
+// extractPDFText converts a PDF to text with pdftotext from poppler-utils.
+func extractPDFText(data []byte) (string, error) {
+	if pdftotextPath == "" {
+		return "", ErrPDFUnavailable
+	}
+	file, err := os.CreateTemp("", "meow-document-*.pdf")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	_ = file.Close()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, pdftotextPath, "-enc", "UTF-8", file.Name(), "-")
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("pdftotext failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return string(output), nil
+}
+
+// extractDocxText reads the paragraphs of a Word document. At most maxDocumentText bytes of text are read.
+func extractDocxText(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsupportedDocument, err)
+	}
+	file, err := archive.Open("word/document.xml")
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsupportedDocument, err)
+	}
+	defer file.Close()
+	var text strings.Builder
+	// The XML is mostly markup, so it's allowed to be a few times larger than the text.
+	limited := &io.LimitedReader{R: file, N: 8 * maxDocumentText}
+	decoder := xml.NewDecoder(limited)
+	inText := false
+	for text.Len() < maxDocumentText {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) || (err != nil && limited.N <= 0) {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("failed to parse document.xml: %w", err)
+		}
+		switch elem := token.(type) {
+		case xml.StartElement:
+			switch elem.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteByte('\t')
+			case "br":
+				text.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch elem.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.WriteString("\n\n")
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(elem)
+			}
+		}
+	}
+	return text.String(), nil
+}
+
+// chunkText splits text into chunks of about the given number of characters, preferring paragraph
+// and word boundaries.
+func chunkText(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if chunk := strings.TrimSpace(current.String()); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		current.Reset()
+	}
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if current.Len() > 0 && utf8.RuneCountInString(current.String())+utf8.RuneCountInString(paragraph) > size {
+			flush()
+		}
+		for _, word := range strings.Fields(paragraph) {
+			if current.Len() > 0 && utf8.RuneCountInString(current.String())+utf8.RuneCountInString(word) >= size {
+				flush()
+			}
+			if current.Len() > 0 && !strings.HasSuffix(current.String(), "\n\n") {
+				current.WriteByte(' ')
+			}
+			current.WriteString(word)
+		}
+		current.WriteString("\n\n")
+	}
+	flush()
+	return chunks
+}
+
+// searchTerms splits text into lowercase words, ignoring very short ones.
+func searchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := words[:0]
+	for _, word := range words {
+		if utf8.RuneCountInString(word) >= 3 {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// relevantChunks ranks the chunks by how well they match the question (TF-IDF) and returns the best ones
+// in document order. If nothing matches, e.g. for "ringkas dokumen ini", the first chunks are returned.
+func relevantChunks(chunks []string, question string, k int) []string {
+	if len(chunks) <= k {
+		return chunks
+	}
+	termFreqs := make([]map[string]int, len(chunks))
+	docFreq := make(map[string]int)
+	for i, chunk := range chunks {
+		termFreqs[i] = make(map[string]int)
+		for _, term := range searchTerms(chunk) {
+			if termFreqs[i][term] == 0 {
+				docFreq[term]++
+			}
+			termFreqs[i][term]++
+		}
+	}
+	scores := make([]float64, len(chunks))
+	for _, term := range searchTerms(question) {
+		if docFreq[term] == 0 {
+			continue
+		}
+		idf := math.Log(1 + float64(len(chunks))/float64(docFreq[term]))
+		for i, tf := range termFreqs {
+			if tf[term] > 0 {
+				scores[i] += (1 + math.Log(float64(tf[term]))) * idf
+			}
+		}
+	}
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+	best := order[:k]
+	sort.Ints(best)
+	result := make([]string, len(best))
+	for i, idx := range best {
+		result[i] = chunks[idx]
+	}
+	return result
+}
+
+// messageDocument returns the document in a message or in the message it replies to, along with the ID
+// of the message containing the document.
+func messageDocument(evt *events.Message) (*waProto.DocumentMessage, types.MessageID) {
+	if doc := evt.Message.GetDocumentMessage(); doc != nil {
+		return doc, evt.Info.ID
+	}
+	ctxInfo := evt.Message.GetExtendedTextMessage().GetContextInfo()
+	quoted := ctxInfo.GetQuotedMessage()
+	if doc := quoted.GetDocumentMessage(); doc != nil {
+		return doc, ctxInfo.GetStanzaId()
+	} else if doc = quoted.GetDocumentWithCaptionMessage().GetMessage().GetDocumentMessage(); doc != nil {
+		return doc, ctxInfo.GetStanzaId()
+	}
+	return nil, ""
+}
+
+// indexDocument returns the chunks of a document, downloading and indexing it first if necessary.
+func indexDocument(chat types.JID, id types.MessageID, doc *waProto.DocumentMessage) ([]string, error) {
+	chunks, err := documents.Chunks(chat, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document index: %w", err)
+	} else if len(chunks) > 0 {
+		return chunks, nil
+	}
+	kind := documentKind(doc)
+	if kind == "" {
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedDocument, doc.GetMimetype())
+	} else if doc.GetFileLength() > uint64(*maxDocumentSize)*1024*1024 {
+		return nil, ErrDocumentTooLarge
+	}
+	data, err := cli.Download(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download document: %w", err)
+	} else if len(data) > *maxDocumentSize*1024*1024 {
+		// The file length in the message is set by the sender and may be wrong.
+		return nil, ErrDocumentTooLarge
+	}
+	text, err := extractDocumentText(kind, data)
+	if err != nil {
+		return nil, err
+	} else if len(text) > maxDocumentText {
+		cut := maxDocumentText
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+	}
+	chunks = chunkText(text, *documentChunkSize)
+	if len(chunks) == 0 {
+		return nil, ErrEmptyDocument
+	}
+	name := doc.GetFileName()
+	if name == "" {
+		name = doc.GetTitle()
+	}
+	err = documents.Add(chat, id, name, chunks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store document: %w", err)
+	}
+	log.Infof("Indexed document %s (%s) in %s as %d chunks", id, name, chat, len(chunks))
+	return chunks, nil
+}
+
+// generateDocumentReply asks the AI a question with the most relevant parts of a document in the prompt.
+func generateDocumentReply(ctx context.Context, chat types.JID, name, question string, chunks []string, partial func(string)) (string, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Pengguna mengirim dokumen %q. Jawab pertanyaan pengguna berdasarkan kutipan dokumen berikut. "+
+		"Jika jawabannya tidak ada di kutipan, katakan bahwa informasinya tidak ditemukan di dokumen.\n", name)
+	for i, chunk := range relevantChunks(chunks, question, *documentTopK) {
+		fmt.Fprintf(&prompt, "\n[Kutipan %d]\n%s\n", i+1, chunk)
+	}
+	req := buildChatRequest(chat, nil, question)
+	req.Messages = append(req.Messages[:1], append([]ChatMessage{{Role: RoleSystem, Content: prompt.String()}}, req.Messages[1:]...)...)
+	req.Partial = partial
+	completion, err := ai.Complete(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return completionText(completion)
+}
+
+// replyDocumentError tells the user why a document couldn't be read.
+func replyDocumentError(evt *events.Message, err error) {
+	switch {
+	case errors.Is(err, ErrDocumentTooLarge):
+		replyQuote(evt, fmt.Sprintf("Maaf, dokumennya terlalu besar (maksimal %d MB) 🙏.", *maxDocumentSize))
+	case errors.Is(err, ErrUnsupportedDocument):
+		replyQuote(evt, "Maaf, jenis dokumen ini tidak didukung. Kirim dokumen PDF, TXT, MD atau DOCX 🙏.")
+	case errors.Is(err, ErrPDFUnavailable):
+		replyQuote(evt, "Maaf, bot ini belum bisa membaca PDF 🙏. Kirim dokumen TXT, MD atau DOCX.")
+	case errors.Is(err, ErrEmptyDocument):
+		replyQuote(evt, "Maaf, aku tidak menemukan teks di dokumen ini 🙏.")
+	default:
+		log.Errorf("Failed to read document for %s: %v", evt.Info.ID, err)
+		replyQuote(evt, "Maaf, dokumennya gagal dibaca 🙏. Coba kirim lagi.")
+	}
+}
+
+// answerDocument answers a question about the document in a message or the message it replies to.
+// AI errors are returned, other errors are replied to directly.
+func answerDocument(ctx context.Context, evt *events.Message, question string) error {
+	doc, id := messageDocument(evt)
+	chunks, err := indexDocument(evt.Info.Chat, id, doc)
+	if err != nil {
+		replyDocumentError(evt, err)
+		return nil
+	}
+	name := doc.GetFileName()
+	defer startTyping(evt, types.ChatPresenceMediaText)()
+	stream := newReplyStream(evt, true)
+	answer, err := generateDocumentReply(aiContext(ctx, evt), evt.Info.Chat, name, question, chunks, stream.Partial())
+	if err != nil {
+		return stream.Abort(err)
+	}
+	ids, err := stream.Finish(answer)
+	if err != nil {
+		log.Errorf("Failed to send AI reply to %s: %v", evt.Info.ID, err)
+	}
+	if len(ids) > 0 {
+		history.Remember(evt.Info.Chat, evt.Info.ID, fmt.Sprintf("[dokumen %s] %s", name, question), ids, answer)
+	}
+	return nil
+}
+
+// handleDocumentMessage indexes documents sent directly to the bot and answers the caption, if there is one.
+func handleDocumentMessage(ctx context.Context, evt *events.Message) error {
+	if err := checkAccess(evt); err != nil {
+		return err
+	}
+	doc := evt.Message.GetDocumentMessage()
+	caption := doc.GetCaption()
+	if caption == "" {
+		chunks, err := indexDocument(evt.Info.Chat, evt.Info.ID, doc)
+		if err != nil {
+			replyDocumentError(evt, err)
+			return nil
+		}
+		replyQuote(evt, fmt.Sprintf("Dokumen *%s* sudah kubaca (%d bagian) 📄. Balas dokumen ini dengan pertanyaanmu.", doc.GetFileName(), len(chunks)))
+		return nil
+	}
+	if verdict, found := moderator.Check(evt.Info.Chat, caption); found {
+		applyModeration(evt, verdict)
+	} else if moderator.Muted(evt.Info.Sender) {
+		log.Debugf("Not answering %s from muted sender %s", evt.Info.ID, evt.Info.Sender)
+	} else {
+		return answerDocument(ctx, evt, caption)
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(&Command{
+		Name:    "dokumen",
+		Aliases: []string{"docs"},
+		Help:    "Tampilkan daftar dokumen yang sudah dibaca bot di chat ini.",
+		Handler: func(ctx *CommandContext) {
+			docs, err := documents.List(ctx.Event.Info.Chat)
+			if err != nil {
+				log.Errorf("Failed to list documents of %s: %v", ctx.Event.Info.Chat, err)
+				ctx.Reply("Maaf, daftar dokumen gagal dibaca 🙏. Coba lagi nanti.")
+				return
+			} else if len(docs) == 0 {
+				ctx.Reply("Belum ada dokumen di chat ini. Kirim dokumen PDF, TXT, MD atau DOCX untuk mulai bertanya.")
+				return
+			}
+			var list strings.Builder
+			list.WriteString("*Daftar dokumen*\n")
+			for i, doc := range docs {
+				fmt.Fprintf(&list, "\n%d. %s (%d bagian, %s)", i+1, doc.Name, doc.Chunks, doc.Timestamp.Format("02/01/2006 15:04"))
+			}
+			ctx.Reply(list.String())
+		},
+	})
+	registerCommand(&Command{
+		Name: "forget",
+		Args: []CommandArg{{Name: "nomor", Type: ArgInt, Optional: true}},
+		Help: "Hapus dokumen dengan nomor dari daftar !dokumen, atau semua dokumen di chat ini. Di grup hanya untuk admin.",
+		Handler: func(ctx *CommandContext) {
+			if ctx.Event.Info.IsGroup && ctx.Level() < LevelAdmin {
+				ctx.Reply("Maaf, di grup perintah ini hanya untuk admin 🙏.")
+				return
+			}
+			chat := ctx.Event.Info.Chat
+			var id types.MessageID
+			if ctx.Has("nomor") {
+				docs, err := documents.List(chat)
+				if err != nil {
+					log.Errorf("Failed to list documents of %s: %v", chat, err)
+					ctx.Reply("Maaf, daftar dokumen gagal dibaca 🙏. Coba lagi nanti.")
+					return
+				}
+				num := ctx.Int("nomor")
+				if num < 1 || num > len(docs) {
+					ctx.Reply("Dokumen nomor %d tidak ada. Lihat daftarnya dengan !dokumen.", num)
+					return
+				}
+				id = docs[num-1].MessageID
+			}
+			err := documents.Forget(chat, id)
+			if err != nil {
+				log.Errorf("Failed to forget documents of %s: %v", chat, err)
+				ctx.Reply("Maaf, dokumen gagal dihapus 🙏. Coba lagi nanti.")
+			} else if id != "" {
+				ctx.Reply("Dokumen nomor %d sudah dihapus.", ctx.Int("nomor"))
+			} else {
+				ctx.Reply("Semua dokumen di chat ini sudah dihapus.")
+			}
+		},
+	})
+}
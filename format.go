@@ -0,0 +1,355 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	mdHeading    = regexp.MustCompile(`(?m)^[ \t]{0,3}#{1,6}[ \t]+(.+?)(?:[ \t]+#+)?[ \t]*$`)
+	mdRule       = regexp.MustCompile(`(?m)^[ \t]{0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdBullet     = regexp.MustCompile(`(?m)^([ \t]*)[*+][ \t]+`)
+	mdBoldItalic = regexp.MustCompile(`\*\*\*([^*\s](?:[^*\n]*?[^*\s])?)\*\*\*`)
+	mdBold       = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+	mdItalic     = regexp.MustCompile(`(^|[^*\w])\*([^*\s](?:[^*\n]*?[^*\s])?)\*($|[^*\w])`)
+	mdStrike     = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+	mdImage      = regexp.MustCompile(`!\[([^\]\n]*)\]\((\S+?)(?:[ \t]+"[^"\n]*")?\)`)
+	mdLink       = regexp.MustCompile(`\[([^\]\n]+)\]\((\S+?)(?:[ \t]+"[^"\n]*")?\)`)
+	mdAutolink   = regexp.MustCompile(`<((?:https?|mailto):[^>\s]+)>`)
+	mdLineBreak  = regexp.MustCompile(`(?i)<br\s*/?>`)
+	mdEscape     = regexp.MustCompile("\\\\([\\\\`*_{}\\[\\]()#+\\-.!|~<>])")
+	mdFence      = regexp.MustCompile("^[ \t]{0,3}(```+|~~~+)[ \t]*[\\w+#.-]*[ \t]*$")
+	mdTableDelim = regexp.MustCompile(`^:?-+:?$`)
+)
+
+// whatsappLiterals replaces escaped formatting characters with lookalikes. WhatsApp has no escape
+// syntax, so this is the only way to show them without formatting the text around them.
+var whatsappLiterals = map[string]string{
+	"*": "∗",
+	"_": "ˍ",
+	"~": "∼",
+	"`": "ˋ",
+}
+
+// formatWhatsApp converts the CommonMark used by most AI models to WhatsApp formatting:
+//
+//   - **bold** becomes *bold*, *italic* becomes _italic_ and ~~strike~~ becomes ~strike~
+//   - headings become bold lines and thematic breaks become a horizontal line
+//   - code spans and fenced code blocks become ```monospace``` without the language tag
+//   - tables become monospace blocks with aligned columns
+//   - links become "text (url)" and backslash-escaped formatting characters are kept literal
+//
+// Text inside code is left as is.
+func formatWhatsApp(text string) string {
+	var out, plain []string
+	flushPlain := func() {
+		if len(plain) > 0 {
+			out = append(out, formatInline(strings.Join(plain, "\n")))
+			plain = nil
+		}
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		nextLine := ""
+		if i+1 < len(lines) {
+			nextLine = lines[i+1]
+		}
+		if match := mdFence.FindStringSubmatch(line); match != nil {
+			flushPlain()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), match[1]); i++ {
+				code = append(code, lines[i])
+			}
+			if i >= len(lines) {
+				// Unclosed blocks, e.g. in a reply that was cut off, end at the last line with text.
+				for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+					code = code[:len(code)-1]
+				}
+			}
+			out = append(out, "```"+strings.Join(code, "\n")+"```")
+		} else if strings.Contains(line, "|") && isTableDelimiter(nextLine) {
+			flushPlain()
+			rows := [][]string{splitTableRow(line)}
+			align := splitTableRow(nextLine)
+			for i += 2; i < len(lines) && strings.Contains(lines[i], "|"); i++ {
+				rows = append(rows, splitTableRow(lines[i]))
+			}
+			i--
+			out = append(out, renderTable(rows, align))
+		} else if strings.TrimSpace(line) != "" && isSetextUnderline(nextLine) {
+			plain = append(plain, "# "+strings.TrimSpace(line))
+			i++
+		} else {
+			plain = append(plain, line)
+		}
+	}
+	flushPlain()
+	return strings.Join(out, "\n")
+}
+
+// isSetextUnderline checks if the line turns the line above it into a heading.
+func isSetextUnderline(line string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) >= 3 && (strings.Trim(line, "=") == "" || strings.Trim(line, "-") == "")
+}
+
+// placeholderBase is the first rune used for placeholders in formatInline. It's the start of
+// supplementary private use area A, which leaves room for 65534 placeholders.
+const placeholderBase = 0xF0000
+
+func isPlaceholder(r rune) bool {
+	return r >= placeholderBase && r <= 0xFFFFD
+}
+
+// formatInline converts the inline markdown of text outside code blocks and tables.
+func formatInline(text string) string {
+	// Code spans and escaped characters are replaced with placeholders from the private use area,
+	// so that the other rules don't touch them. Private use characters that are already in the text
+	// are saved the same way, so that they aren't mistaken for placeholders.
+	var saved []string
+	save := func(s string) string {
+		saved = append(saved, s)
+		return string(rune(placeholderBase + len(saved) - 1))
+	}
+	if strings.IndexFunc(text, isPlaceholder) >= 0 {
+		text = strings.Map(func(r rune) rune {
+			if isPlaceholder(r) {
+				return []rune(save(string(r)))[0]
+			}
+			return r
+		}, text)
+	}
+	text = replaceCodeSpans(text, func(code string) string {
+		return save("```" + code + "```")
+	})
+	text = mdEscape.ReplaceAllStringFunc(text, func(escaped string) string {
+		char := escaped[1:]
+		if literal, ok := whatsappLiterals[char]; ok {
+			char = literal
+		}
+		return save(char)
+	})
+	text = mdLineBreak.ReplaceAllString(text, "\n")
+	text = mdRule.ReplaceAllString(text, "──────────")
+	text = mdHeading.ReplaceAllStringFunc(text, func(heading string) string {
+		return "**" + strings.ReplaceAll(mdHeading.FindStringSubmatch(heading)[1], "**", "") + "**"
+	})
+	text = mdBullet.ReplaceAllString(text, "$1- ")
+	text = mdBoldItalic.ReplaceAllString(text, "**_${1}_**")
+	// Matches include the characters around them, so adjacent italics need a second pass.
+	text = mdItalic.ReplaceAllString(text, "${1}_${2}_$3")
+	text = mdItalic.ReplaceAllString(text, "${1}_${2}_$3")
+	text = mdBold.ReplaceAllString(text, "*$1$2*")
+	text = mdStrike.ReplaceAllString(text, "~$1~")
+	text = mdImage.ReplaceAllStringFunc(text, formatLink(mdImage))
+	text = mdLink.ReplaceAllStringFunc(text, formatLink(mdLink))
+	text = mdAutolink.ReplaceAllString(text, "$1")
+	if len(saved) == 0 {
+		return text
+	}
+	var out strings.Builder
+	for _, r := range text {
+		if isPlaceholder(r) && int(r-placeholderBase) < len(saved) {
+			out.WriteString(saved[r-placeholderBase])
+		} else {
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// replaceCodeSpans replaces code spans, which start and end with backtick strings of the same length,
+// with the result of calling fn with their content. Unclosed and backslash-escaped backticks are left as is.
+func replaceCodeSpans(text string, fn func(code string) string) string {
+	var out strings.Builder
+	for {
+		start := strings.IndexByte(text, '`')
+		if start < 0 {
+			break
+		}
+		ticks := start
+		for ticks < len(text) && text[ticks] == '`' {
+			ticks++
+		}
+		if escaped := len(text[:start]) - len(strings.TrimRight(text[:start], `\`)); escaped%2 == 1 {
+			// Only the first backtick is escaped, the rest may still start a code span.
+			out.WriteString(text[:start+1])
+			text = text[start+1:]
+			continue
+		}
+		fence := text[start:ticks]
+		end := -1
+		for offset := ticks; offset < len(text); {
+			idx := strings.Index(text[offset:], fence)
+			if idx < 0 {
+				break
+			}
+			idx += offset
+			after := idx + len(fence)
+			if after < len(text) && text[after] == '`' {
+				for after < len(text) && text[after] == '`' {
+					after++
+				}
+				offset = after
+				continue
+			}
+			end = idx
+			break
+		}
+		if end < 0 || strings.Contains(text[ticks:end], "\n") {
+			out.WriteString(text[:ticks])
+			text = text[ticks:]
+			continue
+		}
+		out.WriteString(text[:start])
+		out.WriteString(fn(strings.TrimSpace(text[ticks:end])))
+		text = text[end+len(fence):]
+	}
+	out.WriteString(text)
+	return out.String()
+}
+
+// formatLink returns a replacer for links matched by pattern, which writes them as "text (url)",
+// or only the URL if the text is the URL itself.
+func formatLink(pattern *regexp.Regexp) func(string) string {
+	return func(link string) string {
+		match := pattern.FindStringSubmatch(link)
+		label, url := strings.TrimSpace(match[1]), match[2]
+		if label == "" || label == url || label == strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://") {
+			return url
+		}
+		return label + " (" + url + ")"
+	}
+}
+
+// isTableDelimiter checks if the line is the row below a table header, e.g. |---|:--:|.
+func isTableDelimiter(line string) bool {
+	if !strings.Contains(line, "|") {
+		return false
+	}
+	for _, cell := range splitTableRow(line) {
+		if !mdTableDelim.MatchString(cell) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitTableRow splits a table row into trimmed cells. Escaped pipes don't separate cells.
+func splitTableRow(line string) []string {
+	line = strings.TrimPrefix(strings.TrimSpace(line), "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' && i+1 < len(line) && line[i+1] == '|' {
+			cell.WriteByte('|')
+			i++
+		} else if line[i] == '|' {
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		} else {
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// plainCell removes inline markdown from a table cell, since formatting doesn't work inside monospace blocks.
+func plainCell(cell string) string {
+	cell = mdImage.ReplaceAllStringFunc(cell, formatLink(mdImage))
+	cell = mdLink.ReplaceAllStringFunc(cell, formatLink(mdLink))
+	cell = mdEscape.ReplaceAllString(cell, "$1")
+	return strings.NewReplacer("**", "", "__", "", "~~", "", "`", "").Replace(cell)
+}
+
+// renderTable renders a table as a monospace block with the columns padded to the same width.
+// align contains the cells of the delimiter row, which decide the alignment of each column.
+func renderTable(rows [][]string, align []string) string {
+	widths := make([]int, len(align))
+	for _, row := range rows {
+		for col := range row {
+			if col >= len(widths) {
+				break
+			}
+			row[col] = plainCell(row[col])
+			if width := utf8.RuneCountInString(row[col]); width > widths[col] {
+				widths[col] = width
+			}
+		}
+	}
+	renderRow := func(row []string) string {
+		cells := make([]string, len(widths))
+		for col := range cells {
+			var cell string
+			if col < len(row) {
+				cell = row[col]
+			}
+			padding := widths[col] - utf8.RuneCountInString(cell)
+			switch {
+			case strings.HasPrefix(align[col], ":") && strings.HasSuffix(align[col], ":"):
+				cell = strings.Repeat(" ", padding/2) + cell + strings.Repeat(" ", padding-padding/2)
+			case strings.HasSuffix(align[col], ":"):
+				cell = strings.Repeat(" ", padding) + cell
+			default:
+				cell += strings.Repeat(" ", padding)
+			}
+			cells[col] = cell
+		}
+		return strings.TrimRight(strings.Join(cells, " | "), " ")
+	}
+	separators := make([]string, len(widths))
+	for col, width := range widths {
+		separators[col] = strings.Repeat("-", width)
+	}
+	lines := []string{renderRow(rows[0]), strings.Join(separators, "-+-")}
+	for _, row := range rows[1:] {
+		lines = append(lines, renderRow(row))
+	}
+	return "```" + strings.Join(lines, "\n") + "```"
+}
+
+// speechText removes markdown from text that's read aloud, so that speech synthesis doesn't read out
+// formatting characters. Code blocks are kept as plain lines, table rows become comma-separated cells
+// and links are replaced with their text.
+func speechText(text string) string {
+	var out []string
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if mdFence.MatchString(line) || mdRule.MatchString(line) {
+			continue
+		} else if strings.Contains(line, "|") && i+1 < len(lines) && isTableDelimiter(lines[i+1]) {
+			for ; i < len(lines) && strings.Contains(lines[i], "|"); i++ {
+				if !isTableDelimiter(lines[i]) {
+					out = append(out, strings.Join(splitTableRow(lines[i]), ", "))
+				}
+			}
+			i--
+		} else if strings.TrimSpace(line) != "" && isSetextUnderline(line) {
+			continue
+		} else {
+			out = append(out, line)
+		}
+	}
+	text = replaceCodeSpans(strings.Join(out, "\n"), func(code string) string {
+		return code
+	})
+	text = mdLineBreak.ReplaceAllString(text, "\n")
+	text = mdHeading.ReplaceAllString(text, "$1")
+	text = mdBullet.ReplaceAllString(text, "$1- ")
+	text = mdBoldItalic.ReplaceAllString(text, "$1")
+	text = mdItalic.ReplaceAllString(text, "${1}${2}$3")
+	text = mdItalic.ReplaceAllString(text, "${1}${2}$3")
+	text = mdBold.ReplaceAllString(text, "$1$2")
+	text = mdStrike.ReplaceAllString(text, "$1")
+	text = mdImage.ReplaceAllString(text, "$1")
+	text = mdLink.ReplaceAllString(text, "$1")
+	text = mdAutolink.ReplaceAllString(text, "$1")
+	text = mdEscape.ReplaceAllString(text, "$1")
+	return strings.TrimSpace(text)
+}
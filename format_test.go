@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "Update the golden files in testdata")
+
+// TestFormatWhatsApp converts each testdata/format/*.md file and compares the result to the .golden
+// file next to it. Run with -update to rewrite the golden files after an intended change.
+func TestFormatWhatsApp(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "format", "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(inputs) == 0 {
+		t.Fatal("no test cases found")
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".md")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := formatWhatsApp(string(data))
+			golden := strings.TrimSuffix(input, ".md") + ".golden"
+			if *updateGolden {
+				if err = os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			} else if got != string(want) {
+				t.Errorf("output doesn't match %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestSpeechText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"emphasis", "Ini **penting** dan *miring* dan ~~salah~~.", "Ini penting dan miring dan salah."},
+		{"heading", "## Langkah\n\nIkuti ini.", "Langkah\n\nIkuti ini."},
+		{"code", "Jalankan `go build` lalu:\n\n```sh\n./bot -v\n```", "Jalankan go build lalu:\n\n./bot -v"},
+		{"link", "Lihat [dokumentasi](https://example.com) atau <https://example.org>.", "Lihat dokumentasi atau https://example.org."},
+		{"table", "| Nama | Harga |\n|---|--:|\n| Kopi | 5000 |", "Nama, Harga\nKopi, 5000"},
+		{"list", "* satu\n* dua\n\n---\nselesai", "- satu\n- dua\n\nselesai"},
+		{"escapes", `2 \* 3 = 6`, "2 * 3 = 6"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := speechText(test.in); got != test.want {
+				t.Errorf("speechText(%q) = %q, want %q", test.in, got, test.want)
+			}
+		})
+	}
+}
@@ -1,29 +1,33 @@
 module wa2
 
-go 1.19
+go 1.21
 
 require (
+	github.com/go-redis/redis/v8 v8.11.2
 	github.com/joho/godotenv v1.4.0
-	github.com/mattn/go-sqlite3 v1.14.16
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
-	go.mau.fi/whatsmeow v0.0.0-20230104001256-9d98dc9b5702
-	google.golang.org/protobuf v1.28.1
+	go.mau.fi/whatsmeow v0.0.0-20240625083845-6acab596dd8c
+	google.golang.org/protobuf v1.33.0
 )
 
 require (
 	filippo.io/edwards25519 v1.0.0 // indirect
 	github.com/cespare/xxhash/v2 v2.1.1 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/go-redis/redis/v8 v8.11.2 // indirect
 	github.com/golang/protobuf v1.5.0 // indirect
-	github.com/google/uuid v1.2.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/hibiken/asynq v0.24.0 // indirect
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/robfig/cron/v3 v3.0.1 // indirect
-	github.com/sashabaranov/go-gpt3 v0.0.0-20230106202239-cf013d3eb56a // indirect
+	github.com/rs/zerolog v1.32.0 // indirect
 	github.com/spf13/cast v1.3.1 // indirect
 	go.mau.fi/libsignal v0.1.0 // indirect
-	golang.org/x/crypto v0.4.0 // indirect
-	golang.org/x/sys v0.3.0 // indirect
+	go.mau.fi/util v0.4.1 // indirect
+	golang.org/x/crypto v0.23.0 // indirect
+	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4 // indirect
 )
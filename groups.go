@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var groupsAllowed = flag.Bool("groups", false, "Allow group admins to enable the bot in their groups")
+var triggerWord = flag.String("trigger", "meow", "Word that makes the bot answer a group message it wasn't mentioned in")
+
+// groups stores which group chats have the bot enabled.
+var groups *GroupSettings
+
+// GroupSettings keeps the per-group opt-in state.
+type GroupSettings struct {
+	db *sql.DB
+}
+
+const createGroupsTableQuery = `
+CREATE TABLE IF NOT EXISTS meow_groups (
+	chat    TEXT    PRIMARY KEY,
+	enabled BOOLEAN NOT NULL
+)`
+
+// NewGroupSettings wraps an existing database connection and creates the group settings table if necessary.
+func NewGroupSettings(db *sql.DB) (*GroupSettings, error) {
+	_, err := db.Exec(createGroupsTableQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create groups table: %w", err)
+	}
+	return &GroupSettings{db: db}, nil
+}
+
+// Enabled returns true if an admin has enabled the bot in the group.
+func (gs *GroupSettings) Enabled(chat types.JID) (bool, error) {
+	var enabled bool
+	err := gs.db.QueryRow(`SELECT enabled FROM meow_groups WHERE chat=$1`, chat.String()).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// SetEnabled enables or disables the bot in the group.
+func (gs *GroupSettings) SetEnabled(chat types.JID, enabled bool) error {
+	_, err := gs.db.Exec(`
+		INSERT INTO meow_groups (chat, enabled) VALUES ($1, $2)
+		ON CONFLICT (chat) DO UPDATE SET enabled=excluded.enabled
+	`, chat.String(), enabled)
+	return err
+}
+
+// isGroupAdmin checks whether the user is an admin of the group.
+func isGroupAdmin(group, user types.JID) (bool, error) {
+	info, err := cli.GetGroupInfo(group)
+	if err != nil {
+		return false, err
+	}
+	for _, participant := range info.Participants {
+		if participant.JID.User == user.User {
+			return participant.IsAdmin || participant.IsSuperAdmin, nil
+		}
+	}
+	return false, nil
+}
+
+// groupTrigger checks if a group message is addressed to the bot, i.e. it mentions the bot, replies to a
+// message from the bot or starts with the trigger word. The returned text has the mention or trigger removed.
+func groupTrigger(evt *events.Message) (string, bool) {
+	if cli.Store.ID == nil {
+		return "", false
+	}
+	ownJID := cli.Store.ID.ToNonAD()
+	text := evt.Message.GetConversation()
+	ctxInfo := evt.Message.GetExtendedTextMessage().GetContextInfo()
+	if text == "" {
+		text = evt.Message.GetExtendedTextMessage().GetText()
+	}
+	if img := evt.Message.GetImageMessage(); img != nil {
+		text = img.GetCaption()
+		ctxInfo = img.GetContextInfo()
+	}
+	for _, mentioned := range ctxInfo.GetMentionedJid() {
+		if jid, err := types.ParseJID(mentioned); err == nil && jid.User == ownJID.User {
+			return strings.TrimSpace(strings.ReplaceAll(text, "@"+ownJID.User, "")), true
+		}
+	}
+	if participant, err := types.ParseJID(ctxInfo.GetParticipant()); err == nil && participant.User == ownJID.User {
+		return text, true
+	}
+	trigger := *triggerWord
+	if trigger != "" && len(text) >= len(trigger) && strings.EqualFold(text[:len(trigger)], trigger) {
+		rest := text[len(trigger):]
+		if rest == "" || strings.IndexAny(rest[:1], " ,:") == 0 {
+			return strings.TrimSpace(strings.TrimLeft(rest, ",:")), true
+		}
+	}
+	return "", false
+}
+
+// routeGroupMessage moderates messages in groups where the bot is enabled and runs the autoresponder
+// on them. It returns true if the message is addressed to the bot and should be queued for an AI answer,
+// so that other group messages don't take up space in the job queue.
+func routeGroupMessage(evt *events.Message) bool {
+	if !*groupsAllowed {
+		return false
+	}
+	text := evt.Message.GetConversation() + evt.Message.GetExtendedTextMessage().GetText() + evt.Message.GetImageMessage().GetCaption()
+	enabled, err := groups.Enabled(evt.Info.Chat)
+	if err != nil {
+		log.Errorf("Failed to get settings of %s: %v", evt.Info.Chat, err)
+		return false
+	} else if !enabled {
+		return false
+	}
+	if verdict, found := moderator.Check(evt.Info.Chat, text); found {
+		go applyModeration(evt, verdict)
+		return false
+	} else if rule := autoResponder.Match(evt, text); rule != nil {
+		go rule.Respond(evt)
+		return false
+	}
+	question, ok := groupTrigger(evt)
+	if ok && question != "" && !moderator.Muted(evt.Info.Sender) {
+		return true
+	}
+	// Images are cached in case someone asks about them later, but not for users who can't ask.
+	if evt.Message.GetImageMessage() != nil {
+		go func() {
+			if checkAccess(evt) == nil {
+				cacheImage(evt)
+			}
+		}()
+	}
+	return false
+}
+
+// handleGroupMessage answers a group message that routeGroupMessage found to be addressed to the bot.
+func handleGroupMessage(ctx context.Context, evt *events.Message) error {
+	question, _ := groupTrigger(evt)
+	if err := checkAccess(evt); err != nil {
+		return err
+	} else if img, _ := messageImage(evt); img != nil {
+		return answerImage(ctx, evt, question)
+	} else if doc, _ := messageDocument(evt); doc != nil {
+		return answerDocument(ctx, evt, question)
+	}
+	quoted := quotedMessage(evt)
+	defer startTyping(evt, types.ChatPresenceMediaText)()
+	stream := newReplyStream(evt, true)
+	answer, err := generateReply(aiContext(ctx, evt), evt.Info.Chat, quoted, question, stream.Partial())
+	if err != nil {
+		return stream.Abort(err)
+	}
+	ids, err := stream.Finish(answer)
+	if err != nil {
+		log.Errorf("Failed to send AI reply to %s: %v", evt.Info.ID, err)
+	}
+	if len(ids) > 0 {
+		history.Remember(evt.Info.Chat, evt.Info.ID, question, ids, answer)
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(&Command{
+		Name:     "bot",
+		Args:     []CommandArg{{Name: "on/off", Type: ArgString}},
+		Help:     "Aktifkan atau nonaktifkan bot di grup ini.",
+		Level:    LevelAdmin,
+		AnyGroup: true,
+		Handler: func(ctx *CommandContext) {
+			evt := ctx.Event
+			state := strings.ToLower(ctx.String("on/off"))
+			if !evt.Info.IsGroup || !*groupsAllowed {
+				ctx.Reply("Perintah ini hanya bisa dipakai di grup.")
+				return
+			} else if state != "on" && state != "off" {
+				ctx.Reply("Cara pakai: %s", ctx.Command.Usage())
+				return
+			}
+			err := groups.SetEnabled(evt.Info.Chat, state == "on")
+			if err != nil {
+				log.Errorf("Failed to update settings of %s: %v", evt.Info.Chat, err)
+			} else if state == "on" {
+				ctx.Reply("Bot aktif di grup ini. Mention aku, balas pesanku, atau awali pesan dengan \"%s\" untuk bertanya 🙂.", *triggerWord)
+			} else {
+				ctx.Reply("Bot dinonaktifkan di grup ini.")
+			}
+		},
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+var imageBackend = flag.String("image-backend", "", "Image generation backend (openai or fake), defaults to $IMAGE_BACKEND; the image command is disabled without one")
+var imageURL = flag.String("image-url", "", "Base URL of the OpenAI-compatible image API, defaults to $IMAGE_URL")
+var imageModel = flag.String("image-model", "", "Image generation model, defaults to $IMAGE_MODEL")
+var imageQuota = flag.Int("image-quota", 5, "Number of images each user on the free tier can generate per day (0 for unlimited)")
+
+// imageGenTimeout limits the time spent on generating a single image.
+const imageGenTimeout = 2 * time.Minute
+
+// imageGen creates images for the image command, nil if image generation is disabled.
+var imageGen ImageGenerator
+
+// ImageGenerator creates an image from a text prompt. Implementations must be safe for concurrent use.
+type ImageGenerator interface {
+	Generate(ctx context.Context, prompt string) ([]byte, error)
+}
+
+// newImageGenerator creates the ImageGenerator selected with the -image-backend flag or the IMAGE_BACKEND
+// environment variable. It returns nil if no backend is configured.
+func newImageGenerator() (ImageGenerator, error) {
+	switch backend := envOr(*imageBackend, "IMAGE_BACKEND", ""); backend {
+	case "":
+		return nil, nil
+	case "openai":
+		return &OpenAIImageGenerator{
+			BaseURL: envOr(*imageURL, "IMAGE_URL", "https://api.openai.com/v1"),
+			APIKey:  os.Getenv("API_KEY"),
+			Model:   envOr(*imageModel, "IMAGE_MODEL", "dall-e-2"),
+		}, nil
+	case "fake":
+		return FakeImageGenerator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown image generation backend %q", backend)
+	}
+}
+
+// OpenAIImageGenerator uses the image generation endpoint of OpenAI or any server implementing the same API.
+type OpenAIImageGenerator struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+	Client  *http.Client
+}
+
+func (ig *OpenAIImageGenerator) Generate(ctx context.Context, prompt string) ([]byte, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model":           ig.Model,
+		"prompt":          prompt,
+		"n":               1,
+		"size":            "1024x1024",
+		"response_format": "b64_json",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(ig.BaseURL, "/")+"/images/generations", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ig.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+ig.APIKey)
+	}
+	data, err := doAPIRequest(ig.Client, req)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Data []struct {
+			B64JSON string `json:"b64_json"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	} else if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("no images in response")
+	}
+	img, err := base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// FakeImageGenerator is a deterministic ImageGenerator that doesn't call any API, meant for running the bot
+// offline. It returns a single-color PNG with the color derived from the prompt.
+type FakeImageGenerator struct{}
+
+func (FakeImageGenerator) Generate(_ context.Context, prompt string) ([]byte, error) {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(prompt))
+	sum := hash.Sum32()
+	fill := color.RGBA{R: uint8(sum), G: uint8(sum >> 8), B: uint8(sum >> 16), A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = fill.R, fill.G, fill.B, fill.A
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
+
+// generateImage handles the image command.
+func generateImage(ctx *CommandContext) {
+	evt := ctx.Event
+	prompt := ctx.String("prompt")
+	limit := 0
+	if imageGen == nil {
+		ctx.Reply("Fitur pembuatan gambar belum diaktifkan.")
+		return
+	} else if verdict, found := moderator.Check(evt.Info.Chat, prompt); found {
+		log.Infof("Rejected image prompt from %s containing %q", evt.Info.Sender, verdict.Word)
+		ctx.Reply("Maaf, deskripsi gambarnya mengandung kata yang tidak pantas 🙏.")
+		return
+	}
+	if !evt.Info.IsFromMe && !isOwner(evt.Info.Sender) {
+		tier, err := accessPolicy.Check(evt.Info.Sender)
+		if errors.Is(err, ErrBlocked) || errors.Is(err, ErrNotActivated) {
+			sendFallback(evt, err)
+			return
+		} else if err != nil {
+			log.Warnf("Failed to check access of %s, using the free tier: %v", evt.Info.Sender, err)
+			tier = builtinTier(TierFree)
+		}
+		limit = tier.DailyImages
+	}
+	if limit > 0 {
+		ok, err := quotas.Take(evt.Info.Sender, FeatureImage, limit)
+		if err != nil {
+			log.Errorf("Failed to check image quota of %s: %v", evt.Info.Sender, err)
+			ctx.Reply("Maaf, terjadi kesalahan. Coba lagi nanti 🙏.")
+			return
+		} else if !ok {
+			ctx.Reply("Jatah pembuatan gambar hari ini sudah habis (%d gambar per hari). Coba lagi besok 🙂.", limit)
+			return
+		}
+	}
+	reactTo(evt, "⏳")
+	defer reactTo(evt, "")
+	genCtx, cancel := context.WithTimeout(context.Background(), imageGenTimeout)
+	defer cancel()
+	data, err := imageGen.Generate(genCtx, prompt)
+	if err == nil {
+		_, err = replyMedia(evt, data, whatsmeow.MediaImage, "", prompt)
+	}
+	if err != nil {
+		log.Errorf("Failed to generate image for %s: %v", evt.Info.ID, err)
+		if limit > 0 {
+			if err = quotas.Refund(evt.Info.Sender, FeatureImage); err != nil {
+				log.Warnf("Failed to refund image quota of %s: %v", evt.Info.Sender, err)
+			}
+		}
+		ctx.Reply("Maaf, gambarnya gagal dibuat 🙏. Coba lagi nanti.")
+	}
+}
+
+func init() {
+	registerCommand(&Command{
+		Name:     "img",
+		Aliases:  []string{"gambar"},
+		Args:     []CommandArg{{Name: "prompt", Type: ArgText}},
+		Help:     "Buat gambar dari deskripsi.",
+		Cooldown: 30 * time.Second,
+		Handler:  generateImage,
+	})
+}
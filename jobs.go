@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/protobuf/proto"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var jobWorkers = flag.Int("job-workers", 4, "Number of messages answered by the AI at the same time")
+var jobQueueSize = flag.Int("job-queue-size", 1000, "Maximum number of messages waiting for an AI answer")
+var jobTimeout = flag.Duration("job-timeout", 3*time.Minute, "Timeout for answering a single message, including retries of AI backend requests")
+var jobBackend = flag.String("job-queue", "", "Where messages wait for an AI answer (memory or redis), defaults to $JOB_QUEUE or memory")
+var redisURL = flag.String("redis-url", "", "Redis server for the redis job queue, defaults to $REDIS_URL or redis://localhost:6379/0")
+
+// Kinds of jobs, which decide how a message is answered.
+const (
+	JobText     = "text"
+	JobGroup    = "group"
+	JobImage    = "image"
+	JobDocument = "document"
+	JobVoice    = "voice"
+)
+
+// ErrQueueFull is returned by JobQueue.Enqueue when too many messages are already waiting.
+var ErrQueueFull = errors.New("job queue is full")
+
+// JobHandler answers a message. Errors are replied to with a fallback message, so handlers should only
+// return errors before anything has been sent. Jobs aren't retried as a whole: transient errors of the AI
+// and transcription backends are retried by RetryingCompleter and RetryingTranscriber, and whatsmeow
+// retries media downloads.
+type JobHandler func(ctx context.Context, evt *events.Message) error
+
+var jobHandlers = map[string]JobHandler{
+	JobText:     answerText,
+	JobGroup:    handleGroupMessage,
+	JobImage:    handleImageMessage,
+	JobDocument: handleDocumentMessage,
+	JobVoice:    handleVoiceMessage,
+}
+
+// Job is a message waiting to be answered.
+type Job struct {
+	Kind  string
+	Event *events.Message
+}
+
+// jobs answers messages in the background, so that slow AI requests don't block event handling.
+var jobs *JobQueue
+
+// JobQueue runs jobs on a fixed number of workers. Jobs of the same chat are run one at a time in the
+// order they were enqueued, so answers don't overtake each other, while different chats are answered
+// concurrently.
+type JobQueue struct {
+	Workers int
+	Size    int
+	Timeout time.Duration
+	// Redis optionally stores waiting jobs in a Redis list instead of memory, so they survive restarts.
+	Redis *RedisJobStore
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	pending map[types.JID][]*Job
+	running map[types.JID]bool
+	ready   []types.JID
+	count   int
+	// slots has room for one job per worker. Jobs are only taken from Redis when there's a free slot,
+	// so that waiting jobs stay in Redis instead of memory.
+	slots chan struct{}
+}
+
+// NewJobQueue creates a job queue with the given number of workers and maximum number of waiting jobs.
+func NewJobQueue(workers, size int) *JobQueue {
+	jq := &JobQueue{
+		Workers: workers,
+		Size:    size,
+		Timeout: 3 * time.Minute,
+		pending: make(map[types.JID][]*Job),
+		running: make(map[types.JID]bool),
+	}
+	jq.cond = sync.NewCond(&jq.lock)
+	return jq
+}
+
+// newJobQueue creates the job queue configured with flags or environment variables.
+func newJobQueue() (*JobQueue, error) {
+	jq := NewJobQueue(*jobWorkers, *jobQueueSize)
+	jq.Timeout = *jobTimeout
+	switch backend := envOr(*jobBackend, "JOB_QUEUE", "memory"); backend {
+	case "memory":
+	case "redis":
+		client, err := newRedisClient(envOr(*redisURL, "REDIS_URL", "redis://localhost:6379/0"))
+		if err != nil {
+			return nil, err
+		}
+		jq.Redis = NewRedisJobStore(client, "meow:jobs", jq.Size)
+	default:
+		return nil, fmt.Errorf("unknown job queue %q", backend)
+	}
+	return jq, nil
+}
+
+// Start starts the workers, and the Redis consumer if the queue is stored in Redis.
+func (jq *JobQueue) Start() {
+	if jq.Workers < 1 {
+		jq.Workers = 1
+	}
+	if jq.Redis != nil {
+		jq.slots = make(chan struct{}, jq.Workers)
+	}
+	for i := 0; i < jq.Workers; i++ {
+		go jq.work()
+	}
+	if jq.Redis != nil {
+		go jq.Redis.pushAll(jq.Size)
+		go jq.Redis.Consume(jq)
+	}
+	log.Infof("Started %d job workers", jq.Workers)
+}
+
+// Enqueue adds a job to the queue. It never blocks; ErrQueueFull is returned if the queue is full.
+func (jq *JobQueue) Enqueue(job *Job) error {
+	if jq.Redis != nil {
+		return jq.Redis.Enqueue(job)
+	}
+	jq.lock.Lock()
+	defer jq.lock.Unlock()
+	if jq.count >= jq.Size {
+		return ErrQueueFull
+	}
+	jq.add(job)
+	return nil
+}
+
+// schedule adds a job taken from Redis to the queue. The consumer holds a slot for it, so there are never
+// more of them than workers.
+func (jq *JobQueue) schedule(job *Job) {
+	jq.lock.Lock()
+	defer jq.lock.Unlock()
+	jq.add(job)
+}
+
+func (jq *JobQueue) add(job *Job) {
+	chat := job.Event.Info.Chat
+	if len(jq.pending[chat]) == 0 && !jq.running[chat] {
+		jq.ready = append(jq.ready, chat)
+	}
+	jq.pending[chat] = append(jq.pending[chat], job)
+	jq.count++
+	jq.cond.Broadcast()
+}
+
+// next waits for a chat that has jobs and no job running, and returns its oldest job.
+func (jq *JobQueue) next() *Job {
+	jq.lock.Lock()
+	defer jq.lock.Unlock()
+	for len(jq.ready) == 0 {
+		jq.cond.Wait()
+	}
+	chat := jq.ready[0]
+	jq.ready = jq.ready[1:]
+	job := jq.pending[chat][0]
+	if len(jq.pending[chat]) == 1 {
+		delete(jq.pending, chat)
+	} else {
+		jq.pending[chat] = jq.pending[chat][1:]
+	}
+	jq.running[chat] = true
+	jq.count--
+	jq.cond.Broadcast()
+	return job
+}
+
+// done marks the job of the chat as finished, making the next job of the chat available.
+func (jq *JobQueue) done(chat types.JID) {
+	jq.lock.Lock()
+	defer jq.lock.Unlock()
+	delete(jq.running, chat)
+	if len(jq.pending[chat]) > 0 {
+		jq.ready = append(jq.ready, chat)
+		jq.cond.Broadcast()
+	}
+}
+
+// Waiting returns the number of jobs that haven't been started yet.
+func (jq *JobQueue) Waiting() int {
+	jq.lock.Lock()
+	defer jq.lock.Unlock()
+	return jq.count
+}
+
+func (jq *JobQueue) work() {
+	for {
+		job := jq.next()
+		jq.run(job)
+		jq.done(job.Event.Info.Chat)
+		if jq.slots != nil {
+			<-jq.slots
+		}
+	}
+}
+
+// run runs a job and sends a fallback message if it fails.
+func (jq *JobQueue) run(job *Job) {
+	evt := job.Event
+	handler, ok := jobHandlers[job.Kind]
+	if !ok {
+		log.Errorf("Unknown job kind %q for %s", job.Kind, evt.Info.ID)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), jq.Timeout)
+	err := runJobHandler(ctx, handler, evt)
+	cancel()
+	if err != nil {
+		log.Errorf("Failed to get AI reply to %s: %v", evt.Info.ID, err)
+		sendFallback(evt, err)
+	}
+}
+
+// runJobHandler calls the handler, turning panics into errors so that they don't kill the worker.
+func runJobHandler(ctx context.Context, handler JobHandler, evt *events.Message) (err error) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			log.Errorf("Panic while answering %s: %v\n%s", evt.Info.ID, panicErr, debug.Stack())
+			err = fmt.Errorf("panic: %v", panicErr)
+		}
+	}()
+	return handler(ctx, evt)
+}
+
+// enqueueJob queues a message to be answered, telling the user to try again later if the queue is full.
+func enqueueJob(kind string, evt *events.Message) {
+	err := jobs.Enqueue(&Job{Kind: kind, Event: evt})
+	if errors.Is(err, ErrQueueFull) {
+		log.Warnf("Not answering %s: %v", evt.Info.ID, err)
+		go sendFallback(evt, err)
+	} else if err != nil {
+		log.Errorf("Failed to queue %s: %v", evt.Info.ID, err)
+		go sendFallback(evt, err)
+	}
+}
+
+// RedisJobStore keeps waiting jobs in a Redis list. Jobs are pushed to Redis in the background, so that
+// a slow Redis server doesn't block event handling. A single consumer moves them to the in-memory
+// queue when a worker is free, which keeps the per-chat ordering. Jobs that were already taken from
+// Redis are lost if the bot is stopped before answering them, but there are at most as many of them
+// as workers.
+type RedisJobStore struct {
+	Client *redis.Client
+	Key    string
+
+	outbox chan *Job
+}
+
+// NewRedisJobStore creates a store for the Redis list at key. Up to buffer jobs can wait to be pushed.
+func NewRedisJobStore(client *redis.Client, key string, buffer int) *RedisJobStore {
+	return &RedisJobStore{Client: client, Key: key, outbox: make(chan *Job, buffer)}
+}
+
+// Enqueue schedules a job to be pushed to Redis. It never blocks; ErrQueueFull is returned if too many
+// jobs are already waiting to be pushed.
+func (rjs *RedisJobStore) Enqueue(job *Job) error {
+	select {
+	case rjs.outbox <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// pushAll pushes enqueued jobs to Redis, sending a fallback message for the ones that can't be pushed.
+// It never returns.
+func (rjs *RedisJobStore) pushAll(size int) {
+	for job := range rjs.outbox {
+		evt := job.Event
+		err := rjs.Push(context.Background(), job, size)
+		if errors.Is(err, ErrQueueFull) {
+			log.Warnf("Not answering %s: %v", evt.Info.ID, err)
+			go sendFallback(evt, err)
+		} else if err != nil {
+			log.Errorf("Failed to queue %s in redis: %v", evt.Info.ID, err)
+			go sendFallback(evt, err)
+		}
+	}
+}
+
+// redisPushScript adds a job to the list unless it's full, in a single step so that concurrent pushes
+// can't exceed the size.
+var redisPushScript = redis.NewScript(`
+if redis.call('LLEN', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('LPUSH', KEYS[1], ARGV[1])
+return 1`)
+
+// redisJob is the serialized form of a Job.
+type redisJob struct {
+	Kind    string            `json:"kind"`
+	Info    types.MessageInfo `json:"info"`
+	Message []byte            `json:"message"`
+}
+
+// Push adds a job to the Redis list, returning ErrQueueFull if the list already has size jobs.
+func (rjs *RedisJobStore) Push(ctx context.Context, job *Job, size int) error {
+	info := job.Event.Info
+	info.VerifiedName = nil
+	msg := job.Event.RawMessage
+	if msg == nil {
+		msg = job.Event.Message
+	}
+	msgData, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	data, err := json.Marshal(&redisJob{Kind: job.Kind, Info: info, Message: msgData})
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+	pushed, err := redisPushScript.Run(ctx, rjs.Client, []string{rjs.Key}, data, size).Int()
+	if err != nil {
+		return err
+	} else if pushed == 0 {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// Pop takes the oldest job from the Redis list, waiting up to timeout for one. It returns nil if the
+// list stayed empty.
+func (rjs *RedisJobStore) Pop(ctx context.Context, timeout time.Duration) (*Job, error) {
+	items, err := rjs.Client.BRPop(ctx, timeout, rjs.Key).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	job, err := decodeRedisJob([]byte(items[1]))
+	if err != nil {
+		log.Errorf("Failed to decode job from redis: %v", err)
+		return nil, nil
+	}
+	return job, nil
+}
+
+// Consume moves jobs from Redis to the in-memory queue of jq, taking one only when a worker is free.
+// It never returns.
+func (rjs *RedisJobStore) Consume(jq *JobQueue) {
+	for {
+		jq.slots <- struct{}{}
+		job, err := rjs.Pop(context.Background(), 5*time.Second)
+		if job != nil {
+			jq.schedule(job)
+			continue
+		}
+		<-jq.slots
+		if err != nil {
+			log.Errorf("Failed to get jobs from redis: %v", err)
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+func decodeRedisJob(data []byte) (*Job, error) {
+	var rj redisJob
+	err := json.Unmarshal(data, &rj)
+	if err != nil {
+		return nil, err
+	}
+	var msg waProto.Message
+	err = proto.Unmarshal(rj.Message, &msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	evt := &events.Message{Info: rj.Info, RawMessage: &msg}
+	return &Job{Kind: rj.Kind, Event: evt.UnwrapRaw()}, nil
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var llmBackend = flag.String("llm-backend", "", "AI backend (openai, local or fake), defaults to $LLM_BACKEND or openai")
+var llmURL = flag.String("llm-url", "", "Base URL of the OpenAI-compatible API, defaults to $LLM_URL")
+var llmModel = flag.String("llm-model", "", "Model used for AI replies, defaults to $LLM_MODEL")
+
+// ai is the backend used to generate replies.
+var ai Completer
+
+// Roles of chat messages sent to the AI backend.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// ChatMessage is a single role-tagged message in a CompletionRequest.
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Images are attached to the message for vision-capable models.
+	Images []ChatImage `json:"-"`
+}
+
+// ChatImage is an image attached to a ChatMessage.
+type ChatImage struct {
+	Mimetype string
+	Data     []byte
+}
+
+type chatContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+// MarshalJSON encodes messages with images using the content part array of the OpenAI vision API.
+func (msg ChatMessage) MarshalJSON() ([]byte, error) {
+	if len(msg.Images) == 0 {
+		type plainChatMessage ChatMessage
+		return json.Marshal(plainChatMessage(msg))
+	}
+	parts := []chatContentPart{{Type: "text", Text: msg.Content}}
+	for _, img := range msg.Images {
+		part := chatContentPart{Type: "image_url"}
+		part.ImageURL = &struct {
+			URL string `json:"url"`
+		}{URL: "data:" + img.Mimetype + ";base64," + base64.StdEncoding.EncodeToString(img.Data)}
+		parts = append(parts, part)
+	}
+	return json.Marshal(&struct {
+		Role    string            `json:"role"`
+		Content []chatContentPart `json:"content"`
+	}{msg.Role, parts})
+}
+
+// CompletionRequest contains the parameters of a single AI reply.
+type CompletionRequest struct {
+	// Model overrides the default model of the backend, e.g. for requests with images.
+	Model            string
+	Messages         []ChatMessage
+	MaxTokens        int
+	Temperature      float32
+	TopP             float32
+	FrequencyPenalty float32
+	PresencePenalty  float32
+	Stop             []string
+	// Partial is called with the text generated so far while the reply is being generated, if the
+	// backend supports streaming. It's called from the goroutine that called Complete.
+	Partial func(text string)
+}
+
+// Completion is the reply generated by a Completer.
+type Completion struct {
+	Text         string
+	FinishReason string
+	// Tokens is the total number of tokens used by the request as reported by the backend, or 0 if unknown.
+	Tokens int
+}
+
+// Truncated returns true if the backend stopped generating because it ran out of tokens.
+func (c *Completion) Truncated() bool {
+	return c.FinishReason == "length"
+}
+
+// Completer generates AI replies. Implementations must be safe for concurrent use.
+type Completer interface {
+	Complete(ctx context.Context, req CompletionRequest) (*Completion, error)
+}
+
+// envOr returns the flag value if it's set, otherwise the environment variable, otherwise the default.
+func envOr(flagValue, envName, def string) string {
+	if flagValue != "" {
+		return flagValue
+	} else if env := os.Getenv(envName); env != "" {
+		return env
+	}
+	return def
+}
+
+// newCompleter creates the Completer selected with the -llm-backend flag or the LLM_BACKEND environment variable.
+func newCompleter() (Completer, error) {
+	backend := envOr(*llmBackend, "LLM_BACKEND", "openai")
+	switch backend {
+	case "openai":
+		apiKey := os.Getenv("API_KEY")
+		if apiKey == "" {
+			return nil, errors.New("missing API_KEY")
+		}
+		return &OpenAICompleter{
+			BaseURL: envOr(*llmURL, "LLM_URL", "https://api.openai.com/v1"),
+			APIKey:  apiKey,
+			Model:   envOr(*llmModel, "LLM_MODEL", "gpt-3.5-turbo"),
+		}, nil
+	case "local":
+		return &OpenAICompleter{
+			BaseURL: envOr(*llmURL, "LLM_URL", "http://localhost:8080/v1"),
+			APIKey:  os.Getenv("API_KEY"),
+			Model:   envOr(*llmModel, "LLM_MODEL", "local"),
+		}, nil
+	case "fake":
+		return FakeCompleter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown AI backend %q", backend)
+	}
+}
+
+// OpenAICompleter uses the chat completions endpoint of OpenAI or any server implementing the same API
+// (e.g. llama.cpp or Ollama).
+type OpenAICompleter struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+	Client  *http.Client
+}
+
+type openAIChatRequest struct {
+	Model            string        `json:"model"`
+	Messages         []ChatMessage `json:"messages"`
+	MaxTokens        int           `json:"max_tokens,omitempty"`
+	Temperature      float32       `json:"temperature"`
+	TopP             float32       `json:"top_p,omitempty"`
+	FrequencyPenalty float32       `json:"frequency_penalty,omitempty"`
+	PresencePenalty  float32       `json:"presence_penalty,omitempty"`
+	Stop             []string      `json:"stop,omitempty"`
+	Stream           bool          `json:"stream,omitempty"`
+	// StreamOptions asks for the token usage, which isn't included in streamed responses by default.
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIChatChunk struct {
+	Choices []struct {
+		Delta        ChatMessage `json:"delta"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	// Usage is only set in the last chunk, which has no choices.
+	Usage *struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message      ChatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (oc *OpenAICompleter) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	model := oc.Model
+	if req.Model != "" {
+		model = req.Model
+	}
+	var streamOptions *openAIStreamOptions
+	if req.Partial != nil {
+		streamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	body, err := json.Marshal(&openAIChatRequest{
+		Model:            model,
+		Messages:         req.Messages,
+		MaxTokens:        req.MaxTokens,
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		FrequencyPenalty: req.FrequencyPenalty,
+		PresencePenalty:  req.PresencePenalty,
+		Stop:             req.Stop,
+		Stream:           req.Partial != nil,
+		StreamOptions:    streamOptions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	} else if req.Partial != nil {
+		return oc.stream(ctx, body, req.Partial)
+	}
+	var resp openAIChatResponse
+	err = oc.post(ctx, "/chat/completions", body, &resp)
+	if err != nil {
+		return nil, err
+	} else if len(resp.Choices) == 0 {
+		return nil, errors.New("no choices in response")
+	}
+	return &Completion{
+		Text:         strings.TrimSpace(resp.Choices[0].Message.Content),
+		FinishReason: resp.Choices[0].FinishReason,
+		Tokens:       resp.Usage.TotalTokens,
+	}, nil
+}
+
+// stream reads a streamed chat completion, which is sent as server-sent events with one chunk of text each.
+func (oc *OpenAICompleter) stream(ctx context.Context, body []byte, partial func(string)) (*Completion, error) {
+	httpReq, err := oc.newRequest(ctx, "/chat/completions", body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sendAPIRequest(oc.Client, httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var text strings.Builder
+	completion := &Completion{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		var chunk openAIChatChunk
+		err = json.Unmarshal([]byte(data), &chunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse response chunk: %w", err)
+		}
+		if chunk.Usage != nil {
+			completion.Tokens = chunk.Usage.TotalTokens
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		if chunk.Choices[0].FinishReason != "" {
+			completion.FinishReason = chunk.Choices[0].FinishReason
+		}
+		if delta := chunk.Choices[0].Delta.Content; delta != "" {
+			text.WriteString(delta)
+			partial(text.String())
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	completion.Text = strings.TrimSpace(text.String())
+	return completion, nil
+}
+
+func (oc *OpenAICompleter) newRequest(ctx context.Context, path string, body []byte) (*http.Request, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(oc.BaseURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if oc.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+oc.APIKey)
+	}
+	return httpReq, nil
+}
+
+func (oc *OpenAICompleter) post(ctx context.Context, path string, body []byte, into interface{}) error {
+	httpReq, err := oc.newRequest(ctx, path, body)
+	if err != nil {
+		return err
+	}
+	data, err := doAPIRequest(oc.Client, httpReq)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, into)
+	if err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// doAPIRequest sends a request to an OpenAI-compatible API and returns the response body.
+// Non-200 responses are returned as an *APIError.
+func doAPIRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := sendAPIRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return data, nil
+}
+
+// sendAPIRequest sends a request to an OpenAI-compatible API and returns the response for reading the
+// body as it arrives. Non-200 responses are returned as an *APIError.
+func sendAPIRequest(client *http.Client, req *http.Request) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var errResp openAIChatResponse
+		if data, err := io.ReadAll(resp.Body); err == nil && json.Unmarshal(data, &errResp) == nil && errResp.Error != nil {
+			apiErr.Message = errResp.Error.Message
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// FakeCompleter is a deterministic Completer that doesn't call any API, meant for running the bot offline.
+type FakeCompleter struct{}
+
+func (FakeCompleter) Complete(_ context.Context, req CompletionRequest) (*Completion, error) {
+	var last ChatMessage
+	for _, msg := range req.Messages {
+		if msg.Role == RoleUser {
+			last = msg
+		}
+	}
+	if len(last.Images) > 0 {
+		img := last.Images[0]
+		return &Completion{Text: fmt.Sprintf("Kamu kirim gambar %s (%d byte) dan bilang: %s", img.Mimetype, len(img.Data), last.Content), FinishReason: "stop"}, nil
+	}
+	text := "Kamu bilang: " + last.Content
+	if req.Partial != nil {
+		words := strings.SplitAfter(text, " ")
+		for i := range words {
+			req.Partial(strings.Join(words[:i+1], ""))
+		}
+	}
+	return &Completion{Text: text, FinishReason: "stop"}, nil
+}
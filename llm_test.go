@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestOpenAIServer starts a server for the chat completions endpoint that decodes the request and
+// passes it to handler.
+func newTestOpenAIServer(t *testing.T, handler func(w http.ResponseWriter, req *openAIChatRequest)) *OpenAICompleter {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		} else if auth := r.Header.Get("Authorization"); auth != "Bearer test-key" {
+			t.Errorf("got Authorization %q, want %q", auth, "Bearer test-key")
+		}
+		var req openAIChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		handler(w, &req)
+	}))
+	t.Cleanup(srv.Close)
+	return &OpenAICompleter{BaseURL: srv.URL + "/v1/", APIKey: "test-key", Model: "test-model", Client: srv.Client()}
+}
+
+func testCompletionRequest() CompletionRequest {
+	return CompletionRequest{
+		Messages: []ChatMessage{
+			{Role: RoleSystem, Content: "Kamu adalah bot."},
+			{Role: RoleUser, Content: "Halo"},
+		},
+		MaxTokens:   100,
+		Temperature: 0.5,
+	}
+}
+
+func TestOpenAICompleter(t *testing.T) {
+	oc := newTestOpenAIServer(t, func(w http.ResponseWriter, req *openAIChatRequest) {
+		if req.Model != "vision-model" {
+			t.Errorf("got model %q, want %q", req.Model, "vision-model")
+		} else if req.Stream || req.StreamOptions != nil {
+			t.Errorf("got stream %t with options %v for a request without Partial", req.Stream, req.StreamOptions)
+		} else if len(req.Messages) != 2 || req.Messages[1].Content != "Halo" || req.MaxTokens != 100 {
+			t.Errorf("unexpected request %+v", req)
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"  Halo juga!\n"},"finish_reason":"length"}],"usage":{"total_tokens":42}}`)
+	})
+	req := testCompletionRequest()
+	req.Model = "vision-model"
+	completion, err := oc.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Completion{Text: "Halo juga!", FinishReason: "length", Tokens: 42}
+	if *completion != want {
+		t.Errorf("got %+v, want %+v", *completion, want)
+	} else if !completion.Truncated() {
+		t.Error("completion with finish reason length isn't truncated")
+	}
+}
+
+func TestOpenAICompleterStream(t *testing.T) {
+	oc := newTestOpenAIServer(t, func(w http.ResponseWriter, req *openAIChatRequest) {
+		if req.Model != "test-model" {
+			t.Errorf("got model %q, want %q", req.Model, "test-model")
+		} else if !req.Stream || req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+			t.Errorf("got stream %t with options %v, want usage to be included", req.Stream, req.StreamOptions)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"choices":[{"delta":{"role":"assistant","content":""}}]}`,
+			`{"choices":[{"delta":{"content":"Halo"}}]}`,
+			`{"choices":[{"delta":{"content":" juga"}}]}`,
+			`{"choices":[{"delta":{"content":"!"},"finish_reason":"stop"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":3,"total_tokens":13}}`,
+			`[DONE]`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+	})
+	var partials []string
+	req := testCompletionRequest()
+	req.Partial = func(text string) {
+		partials = append(partials, text)
+	}
+	completion, err := oc.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Completion{Text: "Halo juga!", FinishReason: "stop", Tokens: 13}
+	if *completion != want {
+		t.Errorf("got %+v, want %+v", *completion, want)
+	}
+	if wantPartials := []string{"Halo", "Halo juga", "Halo juga!"}; fmt.Sprint(partials) != fmt.Sprint(wantPartials) {
+		t.Errorf("got partials %q, want %q", partials, wantPartials)
+	}
+}
+
+func TestOpenAICompleterError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		stream    bool
+		want      APIError
+		transient bool
+	}{
+		{"RateLimited", http.StatusTooManyRequests, `{"error":{"message":"Rate limit reached"}}`, false, APIError{StatusCode: 429, Message: "Rate limit reached"}, true},
+		{"ContextLength", http.StatusBadRequest, `{"error":{"message":"maximum context length exceeded"}}`, false, APIError{StatusCode: 400, Message: "maximum context length exceeded"}, false},
+		{"NoErrorBody", http.StatusBadGateway, `<html>Bad Gateway</html>`, false, APIError{StatusCode: 502}, true},
+		{"Streaming", http.StatusServiceUnavailable, `{"error":{"message":"overloaded"}}`, true, APIError{StatusCode: 503, Message: "overloaded"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oc := newTestOpenAIServer(t, func(w http.ResponseWriter, _ *openAIChatRequest) {
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			})
+			req := testCompletionRequest()
+			if test.stream {
+				req.Partial = func(text string) {
+					t.Errorf("unexpected partial text %q", text)
+				}
+			}
+			_, err := oc.Complete(context.Background(), req)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got error %v, want an *APIError", err)
+			} else if *apiErr != test.want {
+				t.Errorf("got %+v, want %+v", *apiErr, test.want)
+			} else if isTransient(err) != test.transient {
+				t.Errorf("got transient %t, want %t", isTransient(err), test.transient)
+			}
+		})
+	}
+}
+
+func TestFakeCompleter(t *testing.T) {
+	req := testCompletionRequest()
+	req.Messages = append(req.Messages,
+		ChatMessage{Role: RoleAssistant, Content: "Kamu bilang: Halo"},
+		ChatMessage{Role: RoleUser, Content: "Apa kabar?"},
+	)
+	var partials []string
+	req.Partial = func(text string) {
+		partials = append(partials, text)
+	}
+	completion, err := FakeCompleter{}.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Completion{Text: "Kamu bilang: Apa kabar?", FinishReason: "stop"}
+	if *completion != want {
+		t.Errorf("got %+v, want %+v", *completion, want)
+	}
+	if wantPartials := []string{"Kamu ", "Kamu bilang: ", "Kamu bilang: Apa ", "Kamu bilang: Apa kabar?"}; strings.Join(partials, "|") != strings.Join(wantPartials, "|") {
+		t.Errorf("got partials %q, want %q", partials, wantPartials)
+	}
+
+	req = testCompletionRequest()
+	req.Messages[1].Images = []ChatImage{{Mimetype: "image/png", Data: []byte("png")}}
+	completion, err = FakeCompleter{}.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	} else if want := "Kamu kirim gambar image/png (3 byte) dan bilang: Halo"; completion.Text != want {
+		t.Errorf("got %q, want %q", completion.Text, want)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+
+	"go.mau.fi/whatsmeow"
+)
+
+var loginToken = flag.String("login-token", "", "Access token for the login page, defaults to $LOGIN_TOKEN or a random token printed on startup")
+
+const loginCookie = "meow_login"
+
+// States of the login page.
+const (
+	LoginWaiting  = "waiting"
+	LoginCode     = "code"
+	LoginPairCode = "pair-code"
+	LoginSuccess  = "success"
+	LoginTimeout  = "timeout"
+	LoginError    = "error"
+	LoginLoggedIn = "logged-in"
+)
+
+// LoginStatus is the current QR login state that's streamed to the login page.
+type LoginStatus struct {
+	State string `json:"state"`
+	QR    string `json:"qr,omitempty"`
+	// PairCode is the code to enter on the phone when logging in with a phone number instead of the QR code.
+	PairCode string `json:"pair_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Expires is the unix timestamp (in milliseconds) when the QR code will be replaced.
+	Expires int64 `json:"expires,omitempty"`
+}
+
+// LoginManager tracks the QR channel and notifies connected login pages about changes.
+type LoginManager struct {
+	lock        sync.Mutex
+	status      LoginStatus
+	subscribers map[chan LoginStatus]struct{}
+	token       string
+}
+
+var login = &LoginManager{
+	status:      LoginStatus{State: LoginWaiting},
+	subscribers: make(map[chan LoginStatus]struct{}),
+}
+
+func (lm *LoginManager) set(status LoginStatus) {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+	lm.status = status
+	for sub := range lm.subscribers {
+		select {
+		case sub <- status:
+		default:
+		}
+	}
+}
+
+// Status returns the current login state.
+func (lm *LoginManager) Status() LoginStatus {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+	return lm.status
+}
+
+func (lm *LoginManager) subscribe() chan LoginStatus {
+	ch := make(chan LoginStatus, 4)
+	lm.lock.Lock()
+	lm.subscribers[ch] = struct{}{}
+	lm.lock.Unlock()
+	return ch
+}
+
+func (lm *LoginManager) unsubscribe(ch chan LoginStatus) {
+	lm.lock.Lock()
+	delete(lm.subscribers, ch)
+	lm.lock.Unlock()
+}
+
+// Start requests a QR channel and connects. It must only be called when the device isn't logged in.
+func (lm *LoginManager) Start() error {
+	ch, err := cli.GetQRChannel(context.Background())
+	if errors.Is(err, whatsmeow.ErrQRStoreContainsID) {
+		lm.set(LoginStatus{State: LoginLoggedIn})
+		return cli.Connect()
+	} else if err != nil {
+		return fmt.Errorf("failed to get QR channel: %w", err)
+	}
+	lm.set(LoginStatus{State: LoginWaiting})
+	go lm.consume(ch)
+	return cli.Connect()
+}
+
+func (lm *LoginManager) consume(ch <-chan whatsmeow.QRChannelItem) {
+	for evt := range ch {
+		switch evt.Event {
+		case "code":
+			if lm.Status().State == LoginPairCode {
+				// The QR codes keep rotating while the pairing code is being entered, but they aren't shown.
+				continue
+			}
+			png, err := qrcode.Encode(evt.Code, qrcode.Medium, 512)
+			if err != nil {
+				log.Errorf("Failed to render QR code: %v", err)
+				continue
+			}
+			log.Infof("New QR code available on the login page (http://localhost%s/login)", *httpAddr)
+			lm.set(LoginStatus{
+				State:   LoginCode,
+				QR:      "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+				Expires: time.Now().Add(evt.Timeout).UnixMilli(),
+			})
+		case whatsmeow.QRChannelSuccess.Event:
+			log.Infof("QR login successful")
+			lm.set(LoginStatus{State: LoginSuccess})
+		case whatsmeow.QRChannelTimeout.Event:
+			log.Warnf("QR login timed out")
+			lm.set(LoginStatus{State: LoginTimeout})
+		default:
+			errMsg := evt.Event
+			if evt.Error != nil {
+				errMsg = evt.Error.Error()
+			}
+			log.Errorf("QR channel result: %s", errMsg)
+			lm.set(LoginStatus{State: LoginError, Error: errMsg})
+		}
+	}
+}
+
+// Pair requests a pairing code for logging in by entering a code on the phone with the given number,
+// which must include the country code. The QR channel must be active, i.e. a QR code must have been shown.
+func (lm *LoginManager) Pair(phone string) (string, error) {
+	phone = strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(phone) < 8 {
+		return "", errors.New("invalid phone number")
+	} else if state := lm.Status().State; state != LoginCode && state != LoginPairCode {
+		return "", fmt.Errorf("login isn't waiting for a device (state %s)", state)
+	}
+	code, err := cli.PairPhone(phone, true, whatsmeow.PairClientChrome, "Chrome (Linux)")
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Pairing code requested for +%s", phone)
+	lm.set(LoginStatus{State: LoginPairCode, PairCode: code})
+	return code, nil
+}
+
+func (lm *LoginManager) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if !tokenMatches(requestToken(r, loginCookie), lm.token) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (lm *LoginManager) servePage(w http.ResponseWriter, r *http.Request) {
+	if !lm.authorized(w, r) {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Value:    lm.token,
+		Path:     "/login",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(loginPage))
+}
+
+func (lm *LoginManager) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if !lm.authorized(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(lm.Status())
+}
+
+func (lm *LoginManager) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if !lm.authorized(w, r) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	updates := lm.subscribe()
+	defer lm.unsubscribe(updates)
+	send := func(status LoginStatus) {
+		data, _ := json.Marshal(status)
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	send(lm.Status())
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case status := <-updates:
+			send(status)
+		case <-keepalive.C:
+			_, _ = fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (lm *LoginManager) serveRetry(w http.ResponseWriter, r *http.Request) {
+	if !lm.authorized(w, r) {
+		return
+	} else if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	state := lm.Status().State
+	if state != LoginTimeout && state != LoginError {
+		http.Error(w, "Login is not in a retryable state", http.StatusConflict)
+		return
+	}
+	cli.Disconnect()
+	err := lm.Start()
+	if err != nil {
+		log.Errorf("Failed to restart QR login: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (lm *LoginManager) servePair(w http.ResponseWriter, r *http.Request) {
+	if !lm.authorized(w, r) {
+		return
+	} else if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, err := lm.Pair(r.FormValue("phone"))
+	if err != nil {
+		log.Errorf("Failed to get pairing code: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(lm.Status())
+}
+
+// registerLoginHandlers adds the login page to the HTTP server.
+func registerLoginHandlers() {
+	login.token = envOr(*loginToken, "LOGIN_TOKEN", "")
+	if login.token == "" {
+		login.token = randomToken()
+		log.Infof("No login token configured, open http://localhost%s/login?token=%s to log in", *httpAddr, login.token)
+	}
+	httpMux.HandleFunc("/login", login.servePage)
+	httpMux.HandleFunc("/login/status", login.serveStatus)
+	httpMux.HandleFunc("/login/events", login.serveEvents)
+	httpMux.HandleFunc("/login/retry", login.serveRetry)
+	httpMux.HandleFunc("/login/pair", login.servePair)
+}
+
+const loginPage = `<!DOCTYPE html>
+<html lang="id">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Meow-AI Login</title>
+<style>
+body { font-family: sans-serif; display: flex; flex-direction: column; align-items: center; margin-top: 3em; color: #222; }
+#qr { width: 320px; height: 320px; border: 1px solid #ddd; display: none; }
+#status { margin: 1em; font-size: 1.1em; text-align: center; }
+button { display: none; padding: .5em 1.5em; font-size: 1em; }
+#pair { display: none; margin: 1em; text-align: center; }
+#pair button { display: inline; }
+#code { font-size: 2em; font-family: monospace; letter-spacing: .1em; margin: .5em; }
+</style>
+</head>
+<body>
+<h1>Meow-AI</h1>
+<img id="qr" alt="QR code">
+<div id="code"></div>
+<div id="status">Menunggu kode QR...</div>
+<form id="pair">
+<div>Atau masuk dengan nomor telepon (dengan kode negara, misalnya 628123456789):</div>
+<input id="phone" name="phone" type="tel" placeholder="628123456789" required>
+<button type="submit">Minta kode</button>
+</form>
+<button id="retry">Coba lagi</button>
+<script>
+const qr = document.getElementById("qr");
+const statusText = document.getElementById("status");
+const retry = document.getElementById("retry");
+const pair = document.getElementById("pair");
+const code = document.getElementById("code");
+const messages = {
+	"waiting": "Menunggu kode QR...",
+	"code": "Scan kode QR ini lewat WhatsApp > Perangkat tertaut > Tautkan perangkat.",
+	"pair-code": "Masukkan kode ini lewat WhatsApp > Perangkat tertaut > Tautkan perangkat > Tautkan dengan nomor telepon.",
+	"success": "Berhasil masuk! Bot sudah aktif, halaman ini boleh ditutup.",
+	"logged-in": "Bot sudah masuk.",
+	"timeout": "Kode QR kedaluwarsa.",
+	"error": "Gagal masuk: ",
+};
+function render(status) {
+	qr.style.display = status.state === "code" ? "block" : "none";
+	if (status.qr) qr.src = status.qr;
+	pair.style.display = status.state === "code" ? "block" : "none";
+	code.textContent = status.state === "pair-code" ? status.pair_code : "";
+	statusText.textContent = (messages[status.state] || status.state) + (status.error || "");
+	retry.style.display = status.state === "timeout" || status.state === "error" ? "inline" : "none";
+}
+function connect() {
+	const events = new EventSource("/login/events");
+	events.onmessage = (evt) => render(JSON.parse(evt.data));
+	events.onerror = () => {
+		events.close();
+		setTimeout(() => fetch("/login/status").then((resp) => resp.json()).then(render).finally(connect), 3000);
+	};
+}
+retry.onclick = () => fetch("/login/retry", {method: "POST"});
+pair.onsubmit = (evt) => {
+	evt.preventDefault();
+	fetch("/login/pair", {method: "POST", body: new URLSearchParams(new FormData(pair))}).then(async (resp) => {
+		if (!resp.ok) statusText.textContent = "Gagal meminta kode: " + await resp.text();
+	});
+};
+connect();
+</script>
+</body>
+</html>
+`
@@ -9,29 +9,23 @@ package main
 import (
 	"bufio"
 	"context"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"mime"
-	"net/http"
 	"os"
 	"os/signal"
-	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
-	"sync"
 
 	_ "github.com/mattn/go-sqlite3"
 	//"github.com/mdp/qrterminal/v3"
-	qrcode "github.com/skip2/go-qrcode"
 	"github.com/joho/godotenv"
-	gogpt "github.com/sashabaranov/go-gpt3"
 	//"github.com/hibiken/asynq"
 	"google.golang.org/protobuf/proto"
 
@@ -56,34 +50,10 @@ var dbDialect = flag.String("db-dialect", "sqlite3", "Database dialect (sqlite3
 var dbAddress = flag.String("db-address", "file:ozip.db?_foreign_keys=on", "Database address")
 var requestFullSync = flag.Bool("request-full-sync", false, "Request full (1 year) history sync when logging in?")
 
-
-
-func XhandleRequest(w http.ResponseWriter, r *http.Request) {
-
-    buf, err := ioutil.ReadFile("qr.png")
-
-    if err != nil {
-
-        fmt.Println(err)
-    }
-
-    w.Header().Set("Content-Type", "image/png")
-    w.Write(buf)
-}
-
-func Contains(n int, match func(i int) bool) bool {
-    for i := 0; i < n; i++ {
-        if match(i) {
-            return true
-        }
-    }
-    return false
-}
-
 func main() {
 	waBinary.IndentXML = true
 	flag.Parse()
-  
+
 	if *debugLogs {
 		logLevel = "DEBUG"
 	}
@@ -91,13 +61,110 @@ func main() {
 		store.DeviceProps.RequireFullSync = proto.Bool(true)
 	}
 	log = waLog.Stdout("Main", logLevel, true)
+	godotenv.Load()
+	if *commandPrefixes == "" {
+		log.Errorf("-command-prefixes must contain at least one character")
+		return
+	} else if *maxMessageLength < minMessageLength {
+		log.Errorf("-max-message-length must be at least %d", minMessageLength)
+		return
+	}
 
 	dbLog := waLog.Stdout("Database", logLevel, true)
-	storeContainer, err := sqlstore.New(*dbDialect, *dbAddress, dbLog)
+	db, err := sql.Open(*dbDialect, *dbAddress)
 	if err != nil {
 		log.Errorf("Failed to connect to database: %v", err)
 		return
 	}
+	storeContainer := sqlstore.NewWithDB(db, *dbDialect, dbLog)
+	err = storeContainer.Upgrade()
+	if err != nil {
+		log.Errorf("Failed to upgrade database: %v", err)
+		return
+	}
+	history, err = NewHistoryStore(db)
+	if err != nil {
+		log.Errorf("Failed to set up conversation memory: %v", err)
+		return
+	}
+	err = moderator.Load(*badwordsFile)
+	if err != nil {
+		log.Errorf("Failed to load profanity list: %v", err)
+		return
+	}
+	err = autoResponder.Load(*rulesFile)
+	if err != nil {
+		log.Errorf("Failed to load auto-responder rules: %v", err)
+		return
+	}
+	go autoResponder.Watch(*rulesFile, 5*time.Second)
+	groups, err = NewGroupSettings(db)
+	if err != nil {
+		log.Errorf("Failed to set up group settings: %v", err)
+		return
+	}
+	feedback, err = NewFeedbackSettings(db)
+	if err != nil {
+		log.Errorf("Failed to set up feedback settings: %v", err)
+		return
+	}
+	images, err = NewMediaCache(db)
+	if err != nil {
+		log.Errorf("Failed to set up media cache: %v", err)
+		return
+	}
+	documents, err = NewDocumentStore(db)
+	if err != nil {
+		log.Errorf("Failed to set up document index: %v", err)
+		return
+	}
+	checkPDFSupport()
+	accessPolicy, err = NewAccessPolicy(db)
+	if err != nil {
+		log.Errorf("Failed to set up access policy: %v", err)
+		return
+	} else if *accessMode != AccessOpen && *accessMode != AccessAllowlist {
+		log.Errorf("Unknown access mode %q", *accessMode)
+		return
+	}
+	quotas, err = NewQuotaStore(db)
+	if err != nil {
+		log.Errorf("Failed to set up quotas: %v", err)
+		return
+	}
+	webhooks, err = NewWebhooks(db)
+	if err != nil {
+		log.Errorf("Failed to set up webhooks: %v", err)
+		return
+	}
+	err = webhooks.Load(*webhooksFile)
+	if err != nil {
+		log.Errorf("Failed to load webhook subscriptions: %v", err)
+		return
+	}
+	ai, err = newCompleter()
+	if err != nil {
+		log.Errorf("Failed to set up AI backend: %v", err)
+		return
+	}
+	ai = withUsageLimits(withResilience(ai))
+	stt, tts, err = newVoiceBackends()
+	if err != nil {
+		log.Errorf("Failed to set up voice backends: %v", err)
+		return
+	}
+	voiceLimiter = NewRateLimiter(*voiceRateLimit)
+	imageGen, err = newImageGenerator()
+	if err != nil {
+		log.Errorf("Failed to set up image generation backend: %v", err)
+		return
+	}
+	jobs, err = newJobQueue()
+	if err != nil {
+		log.Errorf("Failed to set up job queue: %v", err)
+		return
+	}
+	jobs.Start()
 	device, err := storeContainer.GetFirstDevice()
 	if err != nil {
 		log.Errorf("Failed to get device: %v", err)
@@ -105,40 +172,22 @@ func main() {
 	}
 
 	cli = whatsmeow.NewClient(device, waLog.Stdout("Client", logLevel, true))
-  //log.Infof("Meow-AI Started")
-  //fmt.Println("----------------------------------")
-	ch, err := cli.GetQRChannel(context.Background())
-	if err != nil {
-		// This error means that we're already logged in, so ignore it.
-		if !errors.Is(err, whatsmeow.ErrQRStoreContainsID) {
-			log.Errorf("Failed to get QR channel: %v", err)
-		}
+	cli.AddEventHandler(handler)
+	registerLoginHandlers()
+	registerAPIHandlers()
+	startHTTPServer()
+	if cli.Store.ID == nil {
+		err = login.Start()
 	} else {
-		go func() {
-			for evt := range ch {
-				if evt.Event == "code" {
-			  qrcode.WriteFile(evt.Code, qrcode.High, 512, "qr.png")
-			  handler := http.HandlerFunc(XhandleRequest)
-
-        http.Handle("/login", handler)
-
-        log.Infof("Server started at port 3000")
-        http.ListenAndServe(":3000", nil)
-				} else {
-					log.Errorf("QR channel result: %s", evt.Event)
-				}
-			}
-		}()
+		login.set(LoginStatus{State: LoginLoggedIn})
+		err = cli.Connect()
 	}
-
-	cli.AddEventHandler(handler)
-	err = cli.Connect()
 	if err != nil {
 		log.Errorf("Failed to connect: %v", err)
 		return
 	}
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	input := make(chan string)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
@@ -166,12 +215,17 @@ func main() {
 			args := strings.Fields(cmd)
 			cmd = args[0]
 			args = args[1:]
-			go handleCmd(strings.ToLower(cmd), args)
+			go handleCmd(strings.ToLower(cmd), args, log)
 		}
 	}
 }
 
 func parseJID(arg string) (types.JID, bool) {
+	return parseJIDTo(log, arg)
+}
+
+// parseJIDTo parses a phone number or JID, writing errors to the given logger.
+func parseJIDTo(out waLog.Logger, arg string) (types.JID, bool) {
 	if arg[0] == '+' {
 		arg = arg[1:]
 	}
@@ -180,34 +234,36 @@ func parseJID(arg string) (types.JID, bool) {
 	} else {
 		recipient, err := types.ParseJID(arg)
 		if err != nil {
-			log.Errorf("Invalid JID %s: %v", arg, err)
+			out.Errorf("Invalid JID %s: %v", arg, err)
 			return recipient, false
 		} else if recipient.User == "" {
-			log.Errorf("Invalid JID %s: no server specified", arg)
+			out.Errorf("Invalid JID %s: no server specified", arg)
 			return recipient, false
 		}
 		return recipient, true
 	}
 }
 
-func handleCmd(cmd string, args []string) {
+// handleCmd runs a console command. The output is written to out, which is the main logger for
+// commands read from stdin.
+func handleCmd(cmd string, args []string, out waLog.Logger) {
 	switch cmd {
 	case "reconnect":
 		cli.Disconnect()
 		err := cli.Connect()
 		if err != nil {
-			log.Errorf("Failed to connect: %v", err)
+			out.Errorf("Failed to connect: %v", err)
 		}
 	case "logout":
 		err := cli.Logout()
 		if err != nil {
-			log.Errorf("Error logging out: %v", err)
+			out.Errorf("Error logging out: %v", err)
 		} else {
-			log.Infof("Successfully logged out")
+			out.Infof("Successfully logged out")
 		}
 	case "appstate":
 		if len(args) < 1 {
-			log.Errorf("Usage: appstate <types...>")
+			out.Errorf("Usage: appstate <types...>")
 			return
 		}
 		names := []appstate.WAPatchName{appstate.WAPatchName(args[0])}
@@ -218,19 +274,19 @@ func handleCmd(cmd string, args []string) {
 		for _, name := range names {
 			err := cli.FetchAppState(name, resync, false)
 			if err != nil {
-				log.Errorf("Failed to sync app state: %v", err)
+				out.Errorf("Failed to sync app state: %v", err)
 			}
 		}
 	case "request-appstate-key":
 		if len(args) < 1 {
-			log.Errorf("Usage: request-appstate-key <ids...>")
+			out.Errorf("Usage: request-appstate-key <ids...>")
 			return
 		}
 		var keyIDs = make([][]byte, len(args))
 		for i, id := range args {
 			decoded, err := hex.DecodeString(id)
 			if err != nil {
-				log.Errorf("Failed to decode %s as hex: %v", id, err)
+				out.Errorf("Failed to decode %s as hex: %v", id, err)
 				return
 			}
 			keyIDs[i] = decoded
@@ -238,78 +294,90 @@ func handleCmd(cmd string, args []string) {
 		cli.DangerousInternals().RequestAppStateKeys(context.Background(), keyIDs)
 	case "checkuser":
 		if len(args) < 1 {
-			log.Errorf("Usage: checkuser <phone numbers...>")
+			out.Errorf("Usage: checkuser <phone numbers...>")
 			return
 		}
 		resp, err := cli.IsOnWhatsApp(args)
 		if err != nil {
-			log.Errorf("Failed to check if users are on WhatsApp:", err)
+			out.Errorf("Failed to check if users are on WhatsApp: %v", err)
 		} else {
 			for _, item := range resp {
 				if item.VerifiedName != nil {
-					log.Infof("%s: on whatsapp: %t, JID: %s, business name: %s", item.Query, item.IsIn, item.JID, item.VerifiedName.Details.GetVerifiedName())
+					out.Infof("%s: on whatsapp: %t, JID: %s, business name: %s", item.Query, item.IsIn, item.JID, item.VerifiedName.Details.GetVerifiedName())
 				} else {
-					log.Infof("%s: on whatsapp: %t, JID: %s", item.Query, item.IsIn, item.JID)
+					out.Infof("%s: on whatsapp: %t, JID: %s", item.Query, item.IsIn, item.JID)
 				}
 			}
 		}
 	case "checkupdate":
-		resp, err := cli.CheckUpdate()
+		resp, err := checkUpdate()
 		if err != nil {
-			log.Errorf("Failed to check for updates: %v", err)
+			out.Errorf("Failed to check for updates: %v", err)
 		} else {
-			log.Debugf("Version data: %#v", resp)
+			out.Debugf("Version data: %#v", resp)
 			if resp.ParsedVersion == store.GetWAVersion() {
-				log.Infof("Client is up to date")
+				out.Infof("Client is up to date")
 			} else if store.GetWAVersion().LessThan(resp.ParsedVersion) {
-				log.Warnf("Client is outdated")
+				out.Warnf("Client is outdated")
 			} else {
-				log.Infof("Client is newer than latest")
+				out.Infof("Client is newer than latest")
 			}
 		}
 	case "subscribepresence":
 		if len(args) < 1 {
-			log.Errorf("Usage: subscribepresence <jid>")
+			out.Errorf("Usage: subscribepresence <jid>")
 			return
 		}
-		jid, ok := parseJID(args[0])
+		jid, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		}
 		err := cli.SubscribePresence(jid)
 		if err != nil {
-			fmt.Println(err)
+			out.Errorf("Failed to subscribe to presence: %v", err)
+		} else {
+			out.Infof("Subscribed to presence of %s", jid)
 		}
 	case "presence":
 		if len(args) == 0 {
-			log.Errorf("Usage: presence <available/unavailable>")
+			out.Errorf("Usage: presence <available/unavailable>")
 			return
 		}
-		fmt.Println(cli.SendPresence(types.Presence(args[0])))
+		err := cli.SendPresence(types.Presence(args[0]))
+		if err != nil {
+			out.Errorf("Failed to send presence: %v", err)
+		} else {
+			out.Infof("Presence sent")
+		}
 	case "chatpresence":
 		if len(args) == 2 {
 			args = append(args, "")
 		} else if len(args) < 2 {
-			log.Errorf("Usage: chatpresence <jid> <composing/paused> [audio]")
+			out.Errorf("Usage: chatpresence <jid> <composing/paused> [audio]")
 			return
 		}
 		jid, _ := types.ParseJID(args[0])
-		fmt.Println(cli.SendChatPresence(jid, types.ChatPresence(args[1]), types.ChatPresenceMedia(args[2])))
+		err := cli.SendChatPresence(jid, types.ChatPresence(args[1]), types.ChatPresenceMedia(args[2]))
+		if err != nil {
+			out.Errorf("Failed to send chat presence: %v", err)
+		} else {
+			out.Infof("Chat presence sent")
+		}
 	case "privacysettings":
 		resp, err := cli.TryFetchPrivacySettings(false)
 		if err != nil {
-			fmt.Println(err)
+			out.Errorf("Failed to get privacy settings: %v", err)
 		} else {
-			fmt.Printf("%+v\n", resp)
+			out.Infof("%+v", resp)
 		}
 	case "getuser":
 		if len(args) < 1 {
-			log.Errorf("Usage: getuser <jids...>")
+			out.Errorf("Usage: getuser <jids...>")
 			return
 		}
 		var jids []types.JID
 		for _, arg := range args {
-			jid, ok := parseJID(arg)
+			jid, ok := parseJIDTo(out, arg)
 			if !ok {
 				return
 			}
@@ -317,18 +385,18 @@ func handleCmd(cmd string, args []string) {
 		}
 		resp, err := cli.GetUserInfo(jids)
 		if err != nil {
-			log.Errorf("Failed to get user info: %v", err)
+			out.Errorf("Failed to get user info: %v", err)
 		} else {
 			for jid, info := range resp {
-				log.Infof("%s: %+v", jid, info)
+				out.Infof("%s: %+v", jid, info)
 			}
 		}
 	case "getavatar":
 		if len(args) < 1 {
-			log.Errorf("Usage: getavatar <jid> [existing ID] [--preview]")
+			out.Errorf("Usage: getavatar <jid> [existing ID] [--preview]")
 			return
 		}
-		jid, ok := parseJID(args[0])
+		jid, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		}
@@ -350,178 +418,181 @@ func handleCmd(cmd string, args []string) {
 			ExistingID:  existingID,
 		})
 		if err != nil {
-			log.Errorf("Failed to get avatar: %v", err)
+			out.Errorf("Failed to get avatar: %v", err)
 		} else if pic != nil {
-			log.Infof("Got avatar ID %s: %s", pic.ID, pic.URL)
+			out.Infof("Got avatar ID %s: %s", pic.ID, pic.URL)
 		} else {
-			log.Infof("No avatar found")
+			out.Infof("No avatar found")
 		}
 	case "getgroup":
 		if len(args) < 1 {
-			log.Errorf("Usage: getgroup <jid>")
+			out.Errorf("Usage: getgroup <jid>")
 			return
 		}
-		group, ok := parseJID(args[0])
+		group, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		} else if group.Server != types.GroupServer {
-			log.Errorf("Input must be a group JID (@%s)", types.GroupServer)
+			out.Errorf("Input must be a group JID (@%s)", types.GroupServer)
 			return
 		}
 		resp, err := cli.GetGroupInfo(group)
 		if err != nil {
-			log.Errorf("Failed to get group info: %v", err)
+			out.Errorf("Failed to get group info: %v", err)
 		} else {
-			log.Infof("Group info: %+v", resp)
+			out.Infof("Group info: %+v", resp)
 		}
 	case "subgroups":
 		if len(args) < 1 {
-			log.Errorf("Usage: subgroups <jid>")
+			out.Errorf("Usage: subgroups <jid>")
 			return
 		}
-		group, ok := parseJID(args[0])
+		group, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		} else if group.Server != types.GroupServer {
-			log.Errorf("Input must be a group JID (@%s)", types.GroupServer)
+			out.Errorf("Input must be a group JID (@%s)", types.GroupServer)
 			return
 		}
 		resp, err := cli.GetSubGroups(group)
 		if err != nil {
-			log.Errorf("Failed to get subgroups: %v", err)
+			out.Errorf("Failed to get subgroups: %v", err)
 		} else {
 			for _, sub := range resp {
-				log.Infof("Subgroup: %+v", sub)
+				out.Infof("Subgroup: %+v", sub)
 			}
 		}
 	case "communityparticipants":
 		if len(args) < 1 {
-			log.Errorf("Usage: communityparticipants <jid>")
+			out.Errorf("Usage: communityparticipants <jid>")
 			return
 		}
-		group, ok := parseJID(args[0])
+		group, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		} else if group.Server != types.GroupServer {
-			log.Errorf("Input must be a group JID (@%s)", types.GroupServer)
+			out.Errorf("Input must be a group JID (@%s)", types.GroupServer)
 			return
 		}
 		resp, err := cli.GetLinkedGroupsParticipants(group)
 		if err != nil {
-			log.Errorf("Failed to get community participants: %v", err)
+			out.Errorf("Failed to get community participants: %v", err)
 		} else {
-			log.Infof("Community participants: %+v", resp)
+			out.Infof("Community participants: %+v", resp)
 		}
 	case "listgroups":
 		groups, err := cli.GetJoinedGroups()
 		if err != nil {
-			log.Errorf("Failed to get group list: %v", err)
+			out.Errorf("Failed to get group list: %v", err)
 		} else {
 			for _, group := range groups {
-				log.Infof("%+v", group)
+				out.Infof("%+v", group)
 			}
 		}
 	case "getinvitelink":
 		if len(args) < 1 {
-			log.Errorf("Usage: getinvitelink <jid> [--reset]")
+			out.Errorf("Usage: getinvitelink <jid> [--reset]")
 			return
 		}
-		group, ok := parseJID(args[0])
+		group, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		} else if group.Server != types.GroupServer {
-			log.Errorf("Input must be a group JID (@%s)", types.GroupServer)
+			out.Errorf("Input must be a group JID (@%s)", types.GroupServer)
 			return
 		}
 		resp, err := cli.GetGroupInviteLink(group, len(args) > 1 && args[1] == "--reset")
 		if err != nil {
-			log.Errorf("Failed to get group invite link: %v", err)
+			out.Errorf("Failed to get group invite link: %v", err)
 		} else {
-			log.Infof("Group invite link: %s", resp)
+			out.Infof("Group invite link: %s", resp)
 		}
 	case "queryinvitelink":
 		if len(args) < 1 {
-			log.Errorf("Usage: queryinvitelink <link>")
+			out.Errorf("Usage: queryinvitelink <link>")
 			return
 		}
 		resp, err := cli.GetGroupInfoFromLink(args[0])
 		if err != nil {
-			log.Errorf("Failed to resolve group invite link: %v", err)
+			out.Errorf("Failed to resolve group invite link: %v", err)
 		} else {
-			log.Infof("Group info: %+v", resp)
+			out.Infof("Group info: %+v", resp)
 		}
 	case "querybusinesslink":
 		if len(args) < 1 {
-			log.Errorf("Usage: querybusinesslink <link>")
+			out.Errorf("Usage: querybusinesslink <link>")
 			return
 		}
 		resp, err := cli.ResolveBusinessMessageLink(args[0])
 		if err != nil {
-			log.Errorf("Failed to resolve business message link: %v", err)
+			out.Errorf("Failed to resolve business message link: %v", err)
 		} else {
-			log.Infof("Business info: %+v", resp)
+			out.Infof("Business info: %+v", resp)
 		}
 	case "joininvitelink":
 		if len(args) < 1 {
-			log.Errorf("Usage: acceptinvitelink <link>")
+			out.Errorf("Usage: acceptinvitelink <link>")
 			return
 		}
 		groupID, err := cli.JoinGroupWithLink(args[0])
 		if err != nil {
-			log.Errorf("Failed to join group via invite link: %v", err)
+			out.Errorf("Failed to join group via invite link: %v", err)
 		} else {
-			log.Infof("Joined %s", groupID)
+			out.Infof("Joined %s", groupID)
 		}
 	case "getstatusprivacy":
 		resp, err := cli.GetStatusPrivacy()
-		fmt.Println(err)
-		fmt.Println(resp)
+		if err != nil {
+			out.Errorf("Failed to get status privacy: %v", err)
+		} else {
+			out.Infof("%+v", resp)
+		}
 	case "setdisappeartimer":
 		if len(args) < 2 {
-			log.Errorf("Usage: setdisappeartimer <jid> <days>")
+			out.Errorf("Usage: setdisappeartimer <jid> <days>")
 			return
 		}
 		days, err := strconv.Atoi(args[1])
 		if err != nil {
-			log.Errorf("Invalid duration: %v", err)
+			out.Errorf("Invalid duration: %v", err)
 			return
 		}
-		recipient, ok := parseJID(args[0])
+		recipient, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		}
 		err = cli.SetDisappearingTimer(recipient, time.Duration(days)*24*time.Hour)
 		if err != nil {
-			log.Errorf("Failed to set disappearing timer: %v", err)
+			out.Errorf("Failed to set disappearing timer: %v", err)
 		}
 	case "send":
 		if len(args) < 2 {
-			log.Errorf("Usage: send <jid> <text>")
+			out.Errorf("Usage: send <jid> <text>")
 			return
 		}
-		recipient, ok := parseJID(args[0])
+		recipient, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		}
 		msg := &waProto.Message{Conversation: proto.String(strings.Join(args[1:], " "))}
 		resp, err := cli.SendMessage(context.Background(), recipient, msg)
 		if err != nil {
-			log.Errorf("Error sending message: %v", err)
+			out.Errorf("Error sending message: %v", err)
 		} else {
-			log.Infof("Message sent (server timestamp: %s)", resp.Timestamp)
+			out.Infof("Message sent (server timestamp: %s)", resp.Timestamp)
 		}
 	case "sendpoll":
 		if len(args) < 7 {
-			log.Errorf("Usage: sendpoll <jid> <max answers> <question> -- <option 1> / <option 2> / ...")
+			out.Errorf("Usage: sendpoll <jid> <max answers> <question> -- <option 1> / <option 2> / ...")
 			return
 		}
-		recipient, ok := parseJID(args[0])
+		recipient, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		}
 		maxAnswers, err := strconv.Atoi(args[1])
 		if err != nil {
-			log.Errorf("Number of max answers must be an integer")
+			out.Errorf("Number of max answers must be an integer")
 			return
 		}
 		remainingArgs := strings.Join(args[2:], " ")
@@ -533,18 +604,18 @@ func handleCmd(cmd string, args []string) {
 		}
 		resp, err := cli.SendMessage(context.Background(), recipient, cli.BuildPollCreation(question, options, maxAnswers))
 		if err != nil {
-			log.Errorf("Error sending message: %v", err)
+			out.Errorf("Error sending message: %v", err)
 		} else {
-			log.Infof("Message sent (server timestamp: %s)", resp.Timestamp)
+			out.Infof("Message sent (server timestamp: %s)", resp.Timestamp)
 		}
 	case "multisend":
 		if len(args) < 3 {
-			log.Errorf("Usage: multisend <jids...> -- <text>")
+			out.Errorf("Usage: multisend <jids...> -- <text>")
 			return
 		}
 		var recipients []types.JID
 		for len(args) > 0 && args[0] != "--" {
-			recipient, ok := parseJID(args[0])
+			recipient, ok := parseJIDTo(out, args[0])
 			args = args[1:]
 			if !ok {
 				return
@@ -552,26 +623,30 @@ func handleCmd(cmd string, args []string) {
 			recipients = append(recipients, recipient)
 		}
 		if len(args) == 0 {
-			log.Errorf("Usage: multisend <jids...> -- <text> (the -- is required)")
+			out.Errorf("Usage: multisend <jids...> -- <text> (the -- is required)")
 			return
 		}
 		msg := &waProto.Message{Conversation: proto.String(strings.Join(args[1:], " "))}
+		var sendWG sync.WaitGroup
+		sendWG.Add(len(recipients))
 		for _, recipient := range recipients {
 			go func(recipient types.JID) {
+				defer sendWG.Done()
 				resp, err := cli.SendMessage(context.Background(), recipient, msg)
 				if err != nil {
-					log.Errorf("Error sending message to %s: %v", recipient, err)
+					out.Errorf("Error sending message to %s: %v", recipient, err)
 				} else {
-					log.Infof("Message sent to %s (server timestamp: %s)", recipient, resp.Timestamp)
+					out.Infof("Message sent to %s (server timestamp: %s)", recipient, resp.Timestamp)
 				}
 			}(recipient)
 		}
+		sendWG.Wait()
 	case "react":
 		if len(args) < 3 {
-			log.Errorf("Usage: react <jid> <message ID> <reaction>")
+			out.Errorf("Usage: react <jid> <message ID> <reaction>")
 			return
 		}
-		recipient, ok := parseJID(args[0])
+		recipient, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		}
@@ -588,81 +663,71 @@ func handleCmd(cmd string, args []string) {
 		msg := &waProto.Message{
 			ReactionMessage: &waProto.ReactionMessage{
 				Key: &waProto.MessageKey{
-					RemoteJid: proto.String(recipient.String()),
+					RemoteJID: proto.String(recipient.String()),
 					FromMe:    proto.Bool(fromMe),
-					Id:        proto.String(messageID),
+					ID:        proto.String(messageID),
 				},
 				Text:              proto.String(reaction),
-				SenderTimestampMs: proto.Int64(time.Now().UnixMilli()),
+				SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
 			},
 		}
 		resp, err := cli.SendMessage(context.Background(), recipient, msg)
 		if err != nil {
-			log.Errorf("Error sending reaction: %v", err)
+			out.Errorf("Error sending reaction: %v", err)
 		} else {
-			log.Infof("Reaction sent (server timestamp: %s)", resp.Timestamp)
+			out.Infof("Reaction sent (server timestamp: %s)", resp.Timestamp)
 		}
 	case "revoke":
 		if len(args) < 2 {
-			log.Errorf("Usage: revoke <jid> <message ID>")
+			out.Errorf("Usage: revoke <jid> <message ID>")
 			return
 		}
-		recipient, ok := parseJID(args[0])
+		recipient, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		}
 		messageID := args[1]
 		resp, err := cli.SendMessage(context.Background(), recipient, cli.BuildRevoke(recipient, types.EmptyJID, messageID))
 		if err != nil {
-			log.Errorf("Error sending revocation: %v", err)
+			out.Errorf("Error sending revocation: %v", err)
 		} else {
-			log.Infof("Revocation sent (server timestamp: %s)", resp.Timestamp)
+			out.Infof("Revocation sent (server timestamp: %s)", resp.Timestamp)
 		}
 	case "sendimg":
 		if len(args) < 2 {
-			log.Errorf("Usage: sendimg <jid> <image path> [caption]")
+			out.Errorf("Usage: sendimg <jid> <image path> [caption]")
 			return
 		}
-		recipient, ok := parseJID(args[0])
+		recipient, ok := parseJIDTo(out, args[0])
 		if !ok {
 			return
 		}
 		data, err := os.ReadFile(args[1])
 		if err != nil {
-			log.Errorf("Failed to read %s: %v", args[0], err)
+			out.Errorf("Failed to read %s: %v", args[0], err)
 			return
 		}
-		uploaded, err := cli.Upload(context.Background(), data, whatsmeow.MediaImage)
+		msg, err := buildMediaMessage(data, whatsmeow.MediaImage, "", strings.Join(args[2:], " "), nil)
 		if err != nil {
-			log.Errorf("Failed to upload file: %v", err)
+			out.Errorf("Failed to upload file: %v", err)
 			return
 		}
-		msg := &waProto.Message{ImageMessage: &waProto.ImageMessage{
-			Caption:       proto.String(strings.Join(args[2:], " ")),
-			Url:           proto.String(uploaded.URL),
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(data)),
-			FileEncSha256: uploaded.FileEncSHA256,
-			FileSha256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(data))),
-		}}
 		resp, err := cli.SendMessage(context.Background(), recipient, msg)
 		if err != nil {
-			log.Errorf("Error sending image message: %v", err)
+			out.Errorf("Error sending image message: %v", err)
 		} else {
-			log.Infof("Image message sent (server timestamp: %s)", resp.Timestamp)
+			out.Infof("Image message sent (server timestamp: %s)", resp.Timestamp)
 		}
 	case "setstatus":
 		if len(args) == 0 {
-			log.Errorf("Usage: setstatus <message>")
+			out.Errorf("Usage: setstatus <message>")
 			return
 		}
 		err := cli.SetStatusMessage(strings.Join(args, " "))
 		if err != nil {
-			log.Errorf("Error setting status message: %v", err)
+			out.Errorf("Error setting status message: %v", err)
 		} else {
-			log.Infof("Status updated")
+			out.Infof("Status updated")
 		}
 	}
 }
@@ -696,231 +761,55 @@ func handler(rawEvt interface{}) {
 	case *events.StreamReplaced:
 		os.Exit(0)
 	case *events.Message:
-	  
+
 		messageBody := evt.Message.GetConversation()
 		messageBodyd := evt.Message.GetExtendedTextMessage().GetText()
-	  messageBodyds := strings.ToLower(messageBodyd)
-	  messageBodys := strings.ToLower(messageBody)
-	  //open ai key
-		godotenv.Load()
-    apiKey := os.Getenv("API_KEY")
-    if apiKey == "" {
-       log.Errorf("Missing API KEY")
-    }
-    ctxx := context.Background()
-    cgpt := gogpt.NewClient(apiKey)
-    
-    // custome respone message
-    ttd := ("\n\n-*AI Bot*")
-    mktr1 := ("Tidak ramah, ⭐ 1 ."+ttd)
-		mozip1 := ("Halo bang 🙂."+ttd)
-		mhalo1 := ("Halo disana, aku adalah bot pintar yang siap menjawab pertanyaan kamu apa saja. Harap gunakan bahasa Indonesia yang baik dan benar. Saya juga bisa bahasa nasional negara lain lho seperti: Inggris, Jepang, China Mandarin, Jerman dan lainnya.\n\n *Pro TIP:* Gunakan quoted message saat membalas pesan agar bot dapat nyambung dalam obrolanmu."+ttd)
-		// command
-		// badwords
-		ktr1 := []string{"kontol","kontoll","bangsat","ngentod","tod","ngentot","asu","asw","celeng","celeh","tai","fuck","itil","jembut","memek","memekk","memekkk","jembutt","jembuttt","pekok","pekokk","pekokkk","itill","ngentott","ngentottt","kontt","konttt","gaberrr","kuntul","asuu","asuu","su","suu","ngic","ngiclik","meki","kontl","kont","kntl","dick","titit","peju","gigolo","bacod","tolol","goblok","gaber","gaberr","peli","pelii","peliii"}
-		  // name
-		ozip1 := []string{"ji","zi","jii","zii","oji","ozi","ozip","ozi saputra","ozipoetra","bang","cok","cuk","lur"}
-		//ozip2 := []string{"oji ","ozi ","ozip "," oji"," ozi"," ozip","ji ","zi "}
-		// sambutan
-		halo1 := []string{"halo","hai","oy","p","ping","hy","tes","woy"}
-    kotor := Contains(len(ktr1), func(i int) bool {
-        return ktr1[i] == messageBodys
-        })
-    ozip := Contains(len(ozip1), func(i int) bool {
-        return ozip1[i] == messageBodys
-        })
-    halo := Contains(len(halo1), func(i int) bool {
-        return halo1[i] == messageBodys
-        })
-    rkotor := Contains(len(ktr1), func(i int) bool {
-        return ktr1[i] == messageBodyds
-        })
-    rozip := Contains(len(ozip1), func(i int) bool {
-        return ozip1[i] == messageBodyds
-        })
-    rhalo := Contains(len(halo1), func(i int) bool {
-        return halo1[i] == messageBodyds
-        })
-    
-    // Main
-    if (!evt.Info.IsFromMe && !evt.Info.IsGroup && evt.Info.MediaType == "" && evt.Message.GetConversation() != "") {
-			//fmt.Println("Received a message!",evt.Info.Sender.User,"|",evt.Message,"|", evt.Info.MediaType)
-			if (kotor == true){
-				go cli.SendMessage(context.Background(), evt.Info.Chat, &waProto.Message{
-				ExtendedTextMessage: &waProto.ExtendedTextMessage{
-					Text: proto.String(mktr1),
-					ContextInfo: &waProto.ContextInfo{
-						StanzaId:      proto.String(evt.Info.ID),
-						Participant:   proto.String(evt.Info.Sender.String()),
-						QuotedMessage: evt.Message,
-					},
-				},
-			})
-			}else if (ozip == true){
-				go cli.SendMessage(context.Background(), evt.Info.Chat, &waProto.Message{
-				ExtendedTextMessage: &waProto.ExtendedTextMessage{
-					Text: proto.String(mozip1),
-					ContextInfo: &waProto.ContextInfo{
-						StanzaId:      proto.String(evt.Info.ID),
-						Participant:   proto.String(evt.Info.Sender.String()),
-						QuotedMessage: evt.Message,
-					},
-				},
-			})
-			}else if (halo == true){
-				go cli.SendMessage(context.Background(), evt.Info.Chat, &waProto.Message{
-				ExtendedTextMessage: &waProto.ExtendedTextMessage{
-					Text: proto.String(mhalo1),
-					ContextInfo: &waProto.ContextInfo{
-						StanzaId:      proto.String(evt.Info.ID),
-						Participant:   proto.String(evt.Info.Sender.String()),
-						QuotedMessage: evt.Message,
-					},
-				},
-			})
-			}else if messageBodys == "meow"{
-			 cli.SendMessage(context.Background(), evt.Info.Chat, cli.BuildPollCreation("Apakah kalian suka meow?", []string{"Suka", "Tidak Suka"}, 1)) 
-			}else{
-			    
-          requ := gogpt.CompletionRequest{
-      		Model: "text-davinci-003",
-      		MaxTokens: 512,
-      		Temperature: 0.9,
-      		Prompt: string("You: "+messageBody+"\nFriend: "),
-      		TopP: 0.3,
-      		FrequencyPenalty: 0.8,
-      		PresencePenalty: 0.0,
-      		Stop: []string{"You:"},
-      	}
-      	
-      	respu, err := cgpt.CreateCompletion(ctxx, requ)
-      	if err != nil {
-      		log.Errorf("CGPT Error",err)
-      	}
-      	
-			  cli.SendMessage(context.Background(), evt.Info.Chat, &waProto.Message{
-					Conversation: proto.String(respu.Choices[0].Text[1:]),
-				})
+		// Main
+		commandText := messageBody + messageBodyd + evt.Message.GetImageMessage().GetCaption() + evt.Message.GetVideoMessage().GetCaption()
+		if commands.IsCommand(commandText) {
+			go commands.Run(evt, commandText)
+		} else if !evt.Info.IsFromMe && !evt.Info.IsGroup && evt.Info.MediaType == "" && evt.Message.GetConversation() != "" {
+			if verdict, found := moderator.Check(evt.Info.Chat, messageBody); found {
+				go applyModeration(evt, verdict)
+			} else if rule := autoResponder.Match(evt, messageBody); rule != nil {
+				go rule.Respond(evt)
+			} else if moderator.Muted(evt.Info.Sender) {
+				log.Debugf("Not answering %s from muted sender %s", evt.Info.ID, evt.Info.Sender)
+			} else {
+				enqueueJob(JobText, evt)
 			}
-		}else if (!evt.Info.IsFromMe && !evt.Info.IsGroup && evt.Info.MediaType == "" && evt.Message.GetExtendedTextMessage().GetText() != ""){
-        
-        reqi := gogpt.CompletionRequest{
-      		Model: "text-davinci-003",
-      		MaxTokens: 512,
-      		Temperature: 0.9,
-      		Prompt: string("Friend: "+evt.Message.GetExtendedTextMessage().GetContextInfo().GetQuotedMessage().GetConversation()+"\nYou: "+evt.Message.GetExtendedTextMessage().GetText()+"\nFriend: "),
-      		TopP: 0.3,
-      		FrequencyPenalty: 0.8,
-      		PresencePenalty: 0.0,
-      		Stop: []string{"You:"},
-      	}
-      	
-      	respi, err := cgpt.CreateCompletion(ctxx, reqi)
-      	if err != nil {
-      		log.Errorf("CGPT Error")
-      	}
-      	
-		  //fmt.Println("Received a quote message!",evt.Info.Sender.User,"|",evt.Message.GetExtendedTextMessage().GetText(),"|", evt.Message.GetExtendedTextMessage().GetContextInfo().GetQuotedMessage().GetConversation())
-		  if (rkotor == true){
-				go cli.SendMessage(context.Background(), evt.Info.Chat, &waProto.Message{
-				ExtendedTextMessage: &waProto.ExtendedTextMessage{
-					Text: proto.String(mktr1),
-					ContextInfo: &waProto.ContextInfo{
-						StanzaId:      proto.String(evt.Info.ID),
-						Participant:   proto.String(evt.Info.Sender.String()),
-						QuotedMessage: evt.Message,
-					},
-				},
-			})
-			}else if (rozip == true){
-				go cli.SendMessage(context.Background(), evt.Info.Chat, &waProto.Message{
-				ExtendedTextMessage: &waProto.ExtendedTextMessage{
-					Text: proto.String(mozip1),
-					ContextInfo: &waProto.ContextInfo{
-						StanzaId:      proto.String(evt.Info.ID),
-						Participant:   proto.String(evt.Info.Sender.String()),
-						QuotedMessage: evt.Message,
-					},
-				},
-			})
-			}else if (rhalo == true){
-				go cli.SendMessage(context.Background(), evt.Info.Chat, &waProto.Message{
-				ExtendedTextMessage: &waProto.ExtendedTextMessage{
-					Text: proto.String(mhalo1),
-					ContextInfo: &waProto.ContextInfo{
-						StanzaId:      proto.String(evt.Info.ID),
-						Participant:   proto.String(evt.Info.Sender.String()),
-						QuotedMessage: evt.Message,
-					},
-				},
-			})
-			}else{
-		  cli.SendMessage(context.Background(), evt.Info.Chat, &waProto.Message{
-				ExtendedTextMessage: &waProto.ExtendedTextMessage{
-					Text: proto.String(respi.Choices[0].Text[1:]),
-					ContextInfo: &waProto.ContextInfo{
-						StanzaId:      proto.String(evt.Info.ID),
-						Participant:   proto.String(evt.Info.Sender.String()),
-						QuotedMessage: evt.Message,
-					},
-				},
-			})
+		} else if !evt.Info.IsFromMe && !evt.Info.IsGroup && evt.Info.MediaType == "" && evt.Message.GetExtendedTextMessage().GetText() != "" {
+			//fmt.Println("Received a quote message!",evt.Info.Sender.User,"|",evt.Message.GetExtendedTextMessage().GetText(),"|", evt.Message.GetExtendedTextMessage().GetContextInfo().GetQuotedMessage().GetConversation())
+			if verdict, found := moderator.Check(evt.Info.Chat, messageBodyd); found {
+				go applyModeration(evt, verdict)
+			} else if rule := autoResponder.Match(evt, messageBodyd); rule != nil {
+				go rule.Respond(evt)
+			} else if moderator.Muted(evt.Info.Sender) {
+				log.Debugf("Not answering %s from muted sender %s", evt.Info.ID, evt.Info.Sender)
+			} else {
+				enqueueJob(JobText, evt)
+			}
+		} else if !evt.Info.IsFromMe && evt.Info.IsGroup && *groupsAllowed && (evt.Info.MediaType == "" || evt.Message.GetImageMessage() != nil) {
+			if routeGroupMessage(evt) {
+				enqueueJob(JobGroup, evt)
+			}
+		} else if !evt.Info.IsFromMe && !evt.Info.IsGroup && evt.Message.GetImageMessage() != nil {
+			enqueueJob(JobImage, evt)
+		} else if !evt.Info.IsFromMe && !evt.Info.IsGroup && evt.Message.GetDocumentMessage() != nil {
+			enqueueJob(JobDocument, evt)
+		} else if !evt.Info.IsFromMe && !evt.Info.IsGroup && stt != nil && evt.Message.GetAudioMessage() != nil {
+			enqueueJob(JobVoice, evt)
+		} else if !evt.Info.IsFromMe && !evt.Info.IsGroup && evt.Info.MediaType != "" {
+			//fmt.Println("Received a image message!",evt.Info.Sender.User,"|",evt.Message.GetExtendedTextMessage().GetText(),"|", evt.Info.MediaType)
+			msg := ("Saat ini bot hanya mendukung pesan teks, gambar dan dokumen, jenis media lain belum didukung 🙏.\n\nBOT: *@ozip.cf*")
+			go replyQuote(evt, msg)
+		} else if evt.Info.IsFromMe == true && evt.Info.MediaType == "" && evt.Message.GetConversation() != "" {
+			//fmt.Println("Received a image message!",evt.Info.Sender.User,"|",evt.Info.Sender,"|", evt.Info.MediaType)
+			if rule := autoResponder.Match(evt, messageBody); rule != nil {
+				go rule.Respond(evt)
 			}
-		}else if(!evt.Info.IsFromMe && !evt.Info.IsGroup && evt.Info.MediaType != ""){
-		//fmt.Println("Received a image message!",evt.Info.Sender.User,"|",evt.Message.GetExtendedTextMessage().GetText(),"|", evt.Info.MediaType)
-     msg := ("Saat ini bot hanya mendukung pesan teks, segala jenis pesan media tidak didukung 🙏.\n\nBOT: *@ozip.cf*")
-				go cli.SendMessage(context.Background(), evt.Info.Chat, &waProto.Message{
-				ExtendedTextMessage: &waProto.ExtendedTextMessage{
-					Text: proto.String(msg),
-					ContextInfo: &waProto.ContextInfo{
-						StanzaId:      proto.String(evt.Info.ID),
-						Participant:   proto.String(evt.Info.Sender.String()),
-						QuotedMessage: evt.Message,
-					},
-				},
-			})
-		}else if(evt.Info.IsFromMe == true && evt.Info.MediaType == "" && evt.Message.GetConversation() != ""){
-		//fmt.Println("Received a image message!",evt.Info.Sender.User,"|",evt.Info.Sender,"|", evt.Info.MediaType)
-		if (messageBodys == "!status"){
-		  cmd := exec.Command("neofetch","--stdout")
-		  outd, err := cmd.Output()
-      if err != nil {
-        fmt.Println("could not run command: ", err)
-      }else{
-        go cmd.Output()
-      }
-     //mssg1 := ("Total RAM: ",memory.Total)
-		  cli.SendMessage(context.Background(), evt.Info.Chat, &waProto.Message{
-				ExtendedTextMessage: &waProto.ExtendedTextMessage{
-					Text: proto.String(string(outd)),
-					ContextInfo: &waProto.ContextInfo{
-						StanzaId:      proto.String(evt.Info.ID),
-						Participant:   proto.String(evt.Info.Sender.String()),
-						QuotedMessage: evt.Message,
-					},
-				},
-			})
-		}else if (messageBodys == "!speedtest"){
-		  cmd := exec.Command("speedtest","--progress=no")
-		  outd, err := cmd.Output()
-      if err != nil {
-        fmt.Println("could not run command: ", err)
-      }
-		  
-     //mssg1 := ("Total RAM: ",memory.Total)
-		  cli.SendMessage(context.Background(), evt.Info.Chat, &waProto.Message{
-				ExtendedTextMessage: &waProto.ExtendedTextMessage{
-					Text: proto.String(string(outd)),
-					ContextInfo: &waProto.ContextInfo{
-						StanzaId:      proto.String(evt.Info.ID),
-						Participant:   proto.String(evt.Info.Sender.String()),
-						QuotedMessage: evt.Message,
-					},
-				},
-			})
-		}
 		}
-  
+
 		metaParts := []string{fmt.Sprintf("pushname: %s", evt.Info.PushName), fmt.Sprintf("timestamp: %s", evt.Info.Timestamp)}
 		if evt.Info.Type != "" {
 			metaParts = append(metaParts, fmt.Sprintf("type: %s", evt.Info.Type))
@@ -945,6 +834,7 @@ func handler(rawEvt interface{}) {
 		}
 
 		log.Infof("Received message %s from %s (%s): %+v", evt.Info.ID, evt.Info.SourceString(), strings.Join(metaParts, ", "), evt.Message)
+		webhooks.Dispatch(messageWebhookEvent(evt))
 
 		if evt.Message.GetPollUpdateMessage() != nil {
 			decrypted, err := cli.DecryptPollVote(evt)
@@ -955,6 +845,7 @@ func handler(rawEvt interface{}) {
 				for _, option := range decrypted.SelectedOptions {
 					log.Infof("- %X", option)
 				}
+				webhooks.Dispatch(pollVoteWebhookEvent(evt, decrypted.SelectedOptions))
 			}
 		} else if evt.Message.GetEncReactionMessage() != nil {
 			decrypted, err := cli.DecryptReaction(evt)
@@ -964,23 +855,9 @@ func handler(rawEvt interface{}) {
 				log.Infof("Decrypted reaction: %+v", decrypted)
 			}
 		}
-
-		img := evt.Message.GetImageMessage()
-		if img != nil {
-			data, err := cli.Download(img)
-			if err != nil {
-				log.Errorf("Failed to download image: %v", err)
-				return
-			}
-			exts, _ := mime.ExtensionsByType(img.GetMimetype())
-			path := fmt.Sprintf("%s%s", evt.Info.ID, exts[0])
-			err = os.WriteFile(path, data, 0600)
-			if err != nil {
-				log.Errorf("Failed to save image: %v", err)
-				return
-			}
-			log.Infof("Saved image in message to %s", path)
-		}
+	case *events.Receipt:
+		log.Debugf("Received %q receipt for %v from %s", evt.Type, evt.MessageIDs, evt.SourceString())
+		webhooks.Dispatch(receiptWebhookEvent(evt))
 	case *events.HistorySync:
 		id := atomic.AddInt32(&historySyncID, 1)
 		fileName := fmt.Sprintf("history-%d-%d.json", startupTime, id)
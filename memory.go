@@ -0,0 +1,235 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+var historyTurns = flag.Int("history-turns", 20, "Number of previous messages per chat remembered for AI replies")
+var historyTokens = flag.Int("history-tokens", 1500, "Approximate token budget for remembered messages in AI prompts")
+
+// history is the per-chat conversation memory, stored in the same database as the whatsmeow store.
+var history *HistoryStore
+
+// Turn is a single remembered message in a chat.
+type Turn struct {
+	Role      string
+	MessageID types.MessageID
+	Content   string
+	Timestamp time.Time
+}
+
+// HistoryStore keeps a rolling window of user and bot messages for each chat.
+type HistoryStore struct {
+	db *sql.DB
+}
+
+const createHistoryTableQuery = `
+CREATE TABLE IF NOT EXISTS meow_history (
+	chat       TEXT   NOT NULL,
+	message_id TEXT   NOT NULL,
+	role       TEXT   NOT NULL,
+	content    TEXT   NOT NULL,
+	timestamp  BIGINT NOT NULL
+)`
+
+const createHistoryIndexQuery = `CREATE INDEX IF NOT EXISTS meow_history_chat_idx ON meow_history (chat, timestamp)`
+
+// meow_history_parts maps the other messages of answers that were split into several messages to the
+// message ID the answer is remembered with.
+const createHistoryPartsTableQuery = `
+CREATE TABLE IF NOT EXISTS meow_history_parts (
+	chat       TEXT NOT NULL,
+	message_id TEXT NOT NULL,
+	turn_id    TEXT NOT NULL,
+	PRIMARY KEY (chat, message_id)
+)`
+
+// NewHistoryStore wraps an existing database connection and creates the history table if necessary.
+func NewHistoryStore(db *sql.DB) (*HistoryStore, error) {
+	for _, query := range []string{createHistoryTableQuery, createHistoryIndexQuery, createHistoryPartsTableQuery} {
+		_, err := db.Exec(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create history table: %w", err)
+		}
+	}
+	return &HistoryStore{db: db}, nil
+}
+
+const insertTurnQuery = `INSERT INTO meow_history (chat, message_id, role, content, timestamp) VALUES ($1, $2, $3, $4, $5)`
+
+const trimHistoryQuery = `
+DELETE FROM meow_history WHERE chat=$1 AND timestamp < (
+	SELECT MIN(timestamp) FROM (
+		SELECT timestamp FROM meow_history WHERE chat=$1 ORDER BY timestamp DESC LIMIT $2
+	) AS recent
+)`
+
+const insertTurnPartQuery = `
+INSERT INTO meow_history_parts (chat, message_id, turn_id) VALUES ($1, $2, $3)
+ON CONFLICT (chat, message_id) DO UPDATE SET turn_id=excluded.turn_id`
+
+const trimHistoryPartsQuery = `
+DELETE FROM meow_history_parts WHERE chat=$1 AND turn_id NOT IN (SELECT message_id FROM meow_history WHERE chat=$1)`
+
+// Add stores a turn and drops anything older than the configured window. Extra message IDs of the turn
+// can be given if it was sent as several messages, so that Get finds it by any of them.
+func (hs *HistoryStore) Add(chat types.JID, turn Turn, partIDs ...types.MessageID) error {
+	if turn.Timestamp.IsZero() {
+		turn.Timestamp = time.Now()
+	}
+	_, err := hs.db.Exec(insertTurnQuery, chat.String(), turn.MessageID, turn.Role, turn.Content, turn.Timestamp.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to insert turn: %w", err)
+	}
+	for _, id := range partIDs {
+		_, err = hs.db.Exec(insertTurnPartQuery, chat.String(), id, turn.MessageID)
+		if err != nil {
+			return fmt.Errorf("failed to insert turn part: %w", err)
+		}
+	}
+	_, err = hs.db.Exec(trimHistoryQuery, chat.String(), *historyTurns)
+	if err != nil {
+		return fmt.Errorf("failed to trim history: %w", err)
+	}
+	_, err = hs.db.Exec(trimHistoryPartsQuery, chat.String())
+	if err != nil {
+		return fmt.Errorf("failed to trim history parts: %w", err)
+	}
+	return nil
+}
+
+const getRecentTurnsQuery = `
+SELECT message_id, role, content, timestamp FROM meow_history
+WHERE chat=$1 ORDER BY timestamp DESC LIMIT $2`
+
+// Recent returns the remembered turns of a chat in chronological order,
+// with the oldest ones dropped until they fit in the token budget.
+// If the latest turn alone exceeds the budget, it's cut off to fit.
+func (hs *HistoryStore) Recent(chat types.JID) ([]Turn, error) {
+	rows, err := hs.db.Query(getRecentTurnsQuery, chat.String(), *historyTurns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+	var turns []Turn
+	budget := *historyTokens
+	for rows.Next() {
+		var turn Turn
+		var ts int64
+		err = rows.Scan(&turn.MessageID, &turn.Role, &turn.Content, &ts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan turn: %w", err)
+		}
+		budget -= estimateTokens(turn.Content)
+		if budget < 0 && len(turns) > 0 {
+			break
+		} else if budget < 0 {
+			turn.Content = truncateTokens(turn.Content, *historyTokens)
+			if turn.Content == "" {
+				break
+			}
+		}
+		turn.Timestamp = time.Unix(0, ts)
+		turns = append(turns, turn)
+	}
+	for i, j := 0, len(turns)-1; i < j; i, j = i+1, j-1 {
+		turns[i], turns[j] = turns[j], turns[i]
+	}
+	return turns, rows.Err()
+}
+
+const getTurnQuery = `
+SELECT role, content, timestamp FROM meow_history WHERE chat=$1 AND message_id=COALESCE(
+	(SELECT turn_id FROM meow_history_parts WHERE chat=$1 AND message_id=$2), $2
+)`
+
+// Get returns the remembered turn with the given message ID, or nil if it isn't remembered anymore.
+// Turns that were sent as several messages can be found by the ID of any of them.
+func (hs *HistoryStore) Get(chat types.JID, id types.MessageID) (*Turn, error) {
+	turn := Turn{MessageID: id}
+	var ts int64
+	err := hs.db.QueryRow(getTurnQuery, chat.String(), id).Scan(&turn.Role, &turn.Content, &ts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	turn.Timestamp = time.Unix(0, ts)
+	return &turn, nil
+}
+
+const clearHistoryQuery = `DELETE FROM meow_history WHERE chat=$1`
+
+// Clear forgets everything remembered about a chat.
+func (hs *HistoryStore) Clear(chat types.JID) error {
+	_, err := hs.db.Exec(clearHistoryQuery, chat.String())
+	if err != nil {
+		return err
+	}
+	_, err = hs.db.Exec(trimHistoryPartsQuery, chat.String())
+	return err
+}
+
+// Messages returns the remembered turns of the chat as role-tagged chat messages, followed by the
+// new message. If the new message quotes an earlier one, the bot's own quoted messages are added as
+// an assistant message (unless it's the last remembered one), while other quoted messages are
+// prepended to the new message as a blockquote.
+func (hs *HistoryStore) Messages(chat types.JID, quoted *QuotedMessage, message string) []ChatMessage {
+	turns, err := hs.Recent(chat)
+	if err != nil {
+		log.Warnf("Failed to load history of %s: %v", chat, err)
+	}
+	messages := make([]ChatMessage, 0, len(turns)+2)
+	for _, turn := range turns {
+		role := turn.Role
+		if role != RoleUser {
+			role = RoleAssistant
+		}
+		messages = append(messages, ChatMessage{Role: role, Content: turn.Content})
+	}
+	if quoted != nil && quoted.Text != "" {
+		if !quoted.FromBot {
+			message = "> " + strings.ReplaceAll(quoted.Text, "\n", "\n> ") + "\n\n" + message
+		} else if len(turns) == 0 || turns[len(turns)-1].Content != quoted.Text {
+			messages = append(messages, ChatMessage{Role: RoleAssistant, Content: quoted.Text})
+		}
+	}
+	return append(messages, ChatMessage{Role: RoleUser, Content: message})
+}
+
+// Remember stores a question and the answer the bot sent for it. answerIDs are the IDs of the
+// messages the answer was sent in, and must not be empty.
+func (hs *HistoryStore) Remember(chat types.JID, questionID types.MessageID, question string, answerIDs []types.MessageID, answer string) {
+	err := hs.Add(chat, Turn{Role: RoleUser, MessageID: questionID, Content: question})
+	if err == nil {
+		err = hs.Add(chat, Turn{Role: RoleAssistant, MessageID: answerIDs[0], Content: answer}, answerIDs[1:]...)
+	}
+	if err != nil {
+		log.Warnf("Failed to remember messages in %s: %v", chat, err)
+	}
+}
+
+// estimateTokens roughly approximates the number of tokens in a string (about 4 characters per token).
+func estimateTokens(s string) int {
+	return len([]rune(s))/4 + 1
+}
+
+// truncateTokens cuts s so that it's estimated at no more than the given number of tokens,
+// ending with an ellipsis if anything was cut.
+func truncateTokens(s string, tokens int) string {
+	runes := []rune(s)
+	keep := (tokens - 1) * 4
+	if len(runes) <= keep+3 {
+		return s
+	} else if keep < 1 {
+		return ""
+	}
+	return string(runes[:keep-1]) + "…"
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+var testChat = types.NewJID("6281234567890", types.DefaultUserServer)
+
+// setupTestHistory replaces the global history with one in an in-memory database.
+func setupTestHistory(t *testing.T) {
+	t.Helper()
+	log = waLog.Noop
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a separate database.
+	db.SetMaxOpenConns(1)
+	history, err = NewHistoryStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		history = nil
+	})
+}
+
+// addTestTurns remembers turns with the given contents, alternating between user and assistant.
+func addTestTurns(t *testing.T, contents ...string) {
+	t.Helper()
+	start := time.Now().Add(-time.Hour)
+	for i, content := range contents {
+		role := RoleUser
+		if i%2 == 1 {
+			role = RoleAssistant
+		}
+		turn := Turn{Role: role, MessageID: fmt.Sprintf("msg%d", i), Content: content, Timestamp: start.Add(time.Duration(i) * time.Second)}
+		if err := history.Add(testChat, turn); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func setFlag[T any](t *testing.T, flag *T, value T) {
+	t.Helper()
+	orig := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = orig })
+}
+
+func TestRecentBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget int
+		turns  []string
+		want   []string
+	}{
+		{"Empty", 100, nil, nil},
+		{"AllFit", 100, []string{"halo", "hai juga"}, []string{"halo", "hai juga"}},
+		// Each 40 character turn is estimated at 11 tokens.
+		{"OldestDropped", 25, []string{strings.Repeat("a", 40), strings.Repeat("b", 40), strings.Repeat("c", 40)},
+			[]string{strings.Repeat("b", 40), strings.Repeat("c", 40)}},
+		{"NoBudget", 0, []string{"halo"}, nil},
+		// A budget of 5 tokens fits 16 characters, the last one of which becomes the ellipsis.
+		{"LatestOverBudget", 5, []string{"halo", strings.Repeat("x", 400)}, []string{strings.Repeat("x", 15) + "…"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupTestHistory(t)
+			setFlag(t, historyTokens, test.budget)
+			addTestTurns(t, test.turns...)
+			turns, err := history.Recent(testChat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, turn := range turns {
+				got = append(got, turn.Content)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHistoryWindow(t *testing.T) {
+	setupTestHistory(t)
+	setFlag(t, historyTurns, 3)
+	addTestTurns(t, "satu", "dua", "tiga", "empat", "lima")
+	turns, err := history.Recent(testChat)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(turns) != 3 || turns[0].Content != "tiga" || turns[2].Content != "lima" {
+		t.Errorf("unexpected turns %+v", turns)
+	}
+	if turn, err := history.Get(testChat, "msg0"); err != nil || turn != nil {
+		t.Errorf("expected msg0 to be forgotten, got %+v, %v", turn, err)
+	}
+}
+
+func TestRememberParts(t *testing.T) {
+	setupTestHistory(t)
+	history.Remember(testChat, "question", "apa itu go?", []types.MessageID{"part1", "part2"}, "Go adalah bahasa pemrograman.")
+	for _, id := range []types.MessageID{"part1", "part2"} {
+		turn, err := history.Get(testChat, id)
+		if err != nil {
+			t.Fatal(err)
+		} else if turn == nil || turn.Role != RoleAssistant || turn.Content != "Go adalah bahasa pemrograman." {
+			t.Errorf("unexpected turn for %s: %+v", id, turn)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var badwordsFile = flag.String("badwords", "badwords.json", "JSON file with the default and per-chat profanity lists")
+var moderationAction = flag.String("moderation-action", ModerationWarn, "Default action for profanity (warn, ignore, delete or mute)")
+var muteDuration = flag.Duration("mute-duration", 10*time.Minute, "How long the AI ignores a sender after the mute moderation action")
+
+// Actions that can be taken when a message contains profanity.
+const (
+	// ModerationWarn replies with a warning quoting the message.
+	ModerationWarn = "warn"
+	// ModerationIgnore doesn't reply to the message at all.
+	ModerationIgnore = "ignore"
+	// ModerationDelete deletes the message for everyone (only in groups where the bot is an admin) and warns otherwise.
+	ModerationDelete = "delete"
+	// ModerationMute warns the sender and makes the AI ignore them for a while.
+	ModerationMute = "mute"
+)
+
+const moderationWarning = "Tidak ramah, ⭐ 1 .\n\n-*AI Bot*"
+
+var defaultBadwords = []string{
+	"kontol", "kntl", "kontl", "kont", "bangsat", "ngentod", "ngentot", "tod", "asu", "asw", "celeng", "celeh",
+	"tai", "fuck", "itil", "jembut", "memek", "meki", "pekok", "kuntul", "ngic", "ngiclik", "dick", "titit", "peju",
+	"gigolo", "bacod", "tolol", "goblok", "gaber", "peli",
+}
+
+// moderator is the profanity filter used for incoming messages.
+var moderator = NewModerator()
+
+// leetReplacer maps common character substitutions back to the letters they replace.
+var leetReplacer = strings.NewReplacer(
+	"0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b", "@", "a", "$", "s",
+)
+
+// normalizedWord is a word of a message in the forms that are matched against forbidden words.
+type normalizedWord struct {
+	// Text contains the letters of the word as written.
+	Text string
+	// Leet is the word with leetspeak undone, or Text if the word has no letters.
+	Leet string
+}
+
+// collapseRepeats collapses runs of the same character, e.g. "kontolll" becomes "kontol".
+func collapseRepeats(word string) string {
+	var collapsed []rune
+	for _, r := range word {
+		if len(collapsed) == 0 || collapsed[len(collapsed)-1] != r {
+			collapsed = append(collapsed, r)
+		}
+	}
+	return string(collapsed)
+}
+
+// onlyLetters removes everything except letters from the word.
+func onlyLetters(word string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) {
+			return -1
+		}
+		return r
+	}, word)
+}
+
+// normalizeWords lowercases the text, splits it into words and collapses repeated letters. Leetspeak
+// is only undone in words that contain at least one letter, so that e.g. "K0NT0LLL" matches "kontol" but
+// plain numbers like "741" are left alone.
+func normalizeWords(text string) []normalizedWord {
+	tokens := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '@' && r != '$'
+	})
+	words := make([]normalizedWord, 0, len(tokens))
+	for _, token := range tokens {
+		word := normalizedWord{Text: collapseRepeats(onlyLetters(token))}
+		word.Leet = word.Text
+		if word.Text != "" {
+			word.Leet = collapseRepeats(onlyLetters(leetReplacer.Replace(token)))
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+// ModerationRules is the list of forbidden words and the action to take for a chat.
+type ModerationRules struct {
+	Words  []string `json:"words"`
+	Action string   `json:"action,omitempty"`
+
+	phrases [][]string
+}
+
+func (rules *ModerationRules) compile() {
+	rules.phrases = make([][]string, 0, len(rules.Words))
+	for _, word := range rules.Words {
+		var phrase []string
+		for _, part := range normalizeWords(word) {
+			phrase = append(phrase, part.Leet)
+		}
+		if len(phrase) > 0 {
+			rules.phrases = append(rules.phrases, phrase)
+		}
+	}
+}
+
+// match returns the first forbidden phrase that appears in the words on word boundaries. Each word
+// matches if either its original or its de-leeted form is the forbidden word.
+func (rules *ModerationRules) match(words []normalizedWord) string {
+	for _, phrase := range rules.phrases {
+	Outer:
+		for i := 0; i+len(phrase) <= len(words); i++ {
+			for j, part := range phrase {
+				if words[i+j].Text != part && words[i+j].Leet != part {
+					continue Outer
+				}
+			}
+			return strings.Join(phrase, " ")
+		}
+	}
+	return ""
+}
+
+// ModerationConfig is the format of the badwords file. Per-chat rules are keyed by chat JID and
+// are used in addition to the default words.
+type ModerationConfig struct {
+	ModerationRules
+	Chats map[string]*ModerationRules `json:"chats"`
+}
+
+// Moderator checks messages for profanity and keeps track of muted senders.
+type Moderator struct {
+	lock   sync.RWMutex
+	config ModerationConfig
+	muted  map[types.JID]time.Time
+}
+
+// NewModerator creates a Moderator that uses the built-in word list.
+func NewModerator() *Moderator {
+	mod := &Moderator{muted: make(map[types.JID]time.Time)}
+	mod.setConfig(ModerationConfig{ModerationRules: ModerationRules{Words: defaultBadwords}})
+	return mod
+}
+
+func (mod *Moderator) setConfig(config ModerationConfig) {
+	config.compile()
+	for _, rules := range config.Chats {
+		rules.compile()
+	}
+	mod.lock.Lock()
+	mod.config = config
+	mod.lock.Unlock()
+}
+
+// Load reads the word lists from a JSON file. If the file doesn't exist, the built-in list is kept.
+func (mod *Moderator) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var config ModerationConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(config.Words) == 0 {
+		config.Words = defaultBadwords
+	}
+	mod.setConfig(config)
+	return nil
+}
+
+// Verdict is the result of checking a message.
+type Verdict struct {
+	Word   string
+	Action string
+}
+
+// Check looks for forbidden words in a message sent to the given chat.
+func (mod *Moderator) Check(chat types.JID, text string) (verdict Verdict, found bool) {
+	words := normalizeWords(text)
+	mod.lock.RLock()
+	defer mod.lock.RUnlock()
+	verdict.Action = mod.config.Action
+	if chatRules, ok := mod.config.Chats[chat.String()]; ok {
+		if chatRules.Action != "" {
+			verdict.Action = chatRules.Action
+		}
+		verdict.Word = chatRules.match(words)
+	}
+	if verdict.Word == "" {
+		verdict.Word = mod.config.match(words)
+	}
+	if verdict.Action == "" {
+		verdict.Action = *moderationAction
+	}
+	return verdict, verdict.Word != ""
+}
+
+// Mute makes the AI ignore the sender for the configured duration.
+func (mod *Moderator) Mute(sender types.JID) {
+	mod.lock.Lock()
+	mod.muted[sender.ToNonAD()] = time.Now().Add(*muteDuration)
+	mod.lock.Unlock()
+}
+
+// Muted returns true if the AI should currently ignore the sender.
+func (mod *Moderator) Muted(sender types.JID) bool {
+	sender = sender.ToNonAD()
+	mod.lock.Lock()
+	defer mod.lock.Unlock()
+	until, ok := mod.muted[sender]
+	if ok && time.Now().After(until) {
+		delete(mod.muted, sender)
+		return false
+	}
+	return ok
+}
+
+// applyModeration takes the action of the verdict on a message.
+func applyModeration(evt *events.Message, verdict Verdict) {
+	log.Infof("Message %s from %s contains %q, action: %s", evt.Info.ID, evt.Info.SourceString(), verdict.Word, verdict.Action)
+	switch verdict.Action {
+	case ModerationIgnore:
+	case ModerationDelete:
+		if evt.Info.IsGroup {
+			_, err := sendMessage(evt.Info.Chat, cli.BuildRevoke(evt.Info.Chat, evt.Info.Sender, evt.Info.ID))
+			if err == nil {
+				return
+			}
+			log.Warnf("Failed to delete message %s, is the bot an admin of %s?", evt.Info.ID, evt.Info.Chat)
+		}
+		replyQuote(evt, moderationWarning)
+	case ModerationMute:
+		moderator.Mute(evt.Info.Sender)
+		replyQuote(evt, fmt.Sprintf("%s\n\nAI tidak akan menjawab pesanmu selama %d menit.", moderationWarning, int(muteDuration.Minutes())))
+	default:
+		replyQuote(evt, moderationWarning)
+	}
+}
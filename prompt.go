@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const defaultPersona = "Kamu adalah Meow-AI, bot WhatsApp pintar yang siap menjawab pertanyaan apa saja. " +
+	"Jawab dengan bahasa Indonesia yang baik dan benar, kecuali pengguna memakai bahasa lain seperti Inggris, Jepang, " +
+	"Mandarin atau Jerman, maka jawab dengan bahasa tersebut. Jawab dengan singkat dan ramah."
+
+var persona = flag.String("persona", "", "System prompt describing the bot persona, defaults to $PERSONA or the built-in Meow-AI persona")
+var maxTokens = flag.Int("max-tokens", 1024, "Maximum length of an AI reply in tokens")
+
+// ErrEmptyCompletion is returned by completionText if the backend didn't generate any text.
+var ErrEmptyCompletion = errors.New("empty completion")
+
+// buildChatRequest creates the request for replying to a message in the given chat, including the
+// system persona, the remembered conversation and the quoted message (if any).
+func buildChatRequest(chat types.JID, quoted *QuotedMessage, message string) CompletionRequest {
+	messages := []ChatMessage{{Role: RoleSystem, Content: envOr(*persona, "PERSONA", defaultPersona)}}
+	return CompletionRequest{
+		Messages:         append(messages, history.Messages(chat, quoted, message)...),
+		MaxTokens:        *maxTokens,
+		Temperature:      0.9,
+		TopP:             0.3,
+		FrequencyPenalty: 0.8,
+	}
+}
+
+// generateReply asks the AI backend for a reply to a message and returns the text to send. If partial
+// isn't nil, the reply is streamed to it while it's being generated.
+func generateReply(ctx context.Context, chat types.JID, quoted *QuotedMessage, message string, partial func(string)) (string, error) {
+	req := buildChatRequest(chat, quoted, message)
+	req.Partial = partial
+	completion, err := ai.Complete(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return completionText(completion)
+}
+
+// completionText returns the text to send for a completion. Truncated completions are cut at the
+// last finished sentence (or marked with an ellipsis if there isn't one).
+func completionText(completion *Completion) (string, error) {
+	text := strings.TrimSpace(completion.Text)
+	if text == "" {
+		return "", ErrEmptyCompletion
+	} else if !completion.Truncated() {
+		return text, nil
+	}
+	if end := strings.LastIndexAny(text, ".!?\n"); end > len(text)/2 {
+		return strings.TrimSpace(text[:end+1]), nil
+	}
+	return text + "…", nil
+}
+
+// answerText answers a direct text message, which may be a reply to an earlier message, image or document.
+// AI errors are returned so that the job queue can retry them or send a fallback message.
+func answerText(ctx context.Context, evt *events.Message) error {
+	if err := checkAccess(evt); err != nil {
+		return err
+	}
+	text, quote := evt.Message.GetConversation(), false
+	if text == "" {
+		text, quote = evt.Message.GetExtendedTextMessage().GetText(), true
+	}
+	if img, _ := messageImage(evt); img != nil {
+		return answerImage(ctx, evt, text)
+	} else if doc, _ := messageDocument(evt); doc != nil {
+		return answerDocument(ctx, evt, text)
+	}
+	quoted := quotedMessage(evt)
+	defer startTyping(evt, types.ChatPresenceMediaText)()
+	stream := newReplyStream(evt, quote)
+	answer, err := generateReply(aiContext(ctx, evt), evt.Info.Chat, quoted, text, stream.Partial())
+	if err != nil {
+		return stream.Abort(err)
+	}
+	ids, err := stream.Finish(answer)
+	if err != nil {
+		log.Errorf("Failed to send AI reply to %s: %v", evt.Info.ID, err)
+	}
+	if len(ids) > 0 {
+		history.Remember(evt.Info.Chat, evt.Info.ID, text, ids, answer)
+	}
+	return nil
+}
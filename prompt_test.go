@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCompletionText(t *testing.T) {
+	tests := []struct {
+		name       string
+		completion Completion
+		want       string
+		wantErr    error
+	}{
+		{"Complete", Completion{Text: "  Halo!  ", FinishReason: "stop"}, "Halo!", nil},
+		{"Empty", Completion{Text: " \n ", FinishReason: "stop"}, "", ErrEmptyCompletion},
+		{"TruncatedAtSentence", Completion{Text: "Kalimat pertama selesai. Kalimat kedua bel", FinishReason: "length"},
+			"Kalimat pertama selesai.", nil},
+		{"TruncatedWithoutSentence", Completion{Text: "Kalimat yang sangat panjang tanpa titik", FinishReason: "length"},
+			"Kalimat yang sangat panjang tanpa titik…", nil},
+		{"TruncatedEarlySentence", Completion{Text: "Ya. Lalu kalimat panjang yang terpotong di tengah", FinishReason: "length"},
+			"Ya. Lalu kalimat panjang yang terpotong di tengah…", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := completionText(&test.completion)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got error %v, want %v", err, test.wantErr)
+			} else if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildChatRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []string
+		quoted  *QuotedMessage
+		message string
+		want    []ChatMessage
+	}{
+		{
+			name:    "NoHistory",
+			message: "halo",
+			want:    []ChatMessage{{Role: RoleUser, Content: "halo"}},
+		},
+		{
+			name:    "History",
+			history: []string{"siapa kamu?", "Aku Meow-AI."},
+			message: "kamu bisa apa?",
+			want:    []ChatMessage{{Role: RoleUser, Content: "siapa kamu?"}, {Role: RoleAssistant, Content: "Aku Meow-AI."}, {Role: RoleUser, Content: "kamu bisa apa?"}},
+		},
+		{
+			name:    "QuotedUser",
+			quoted:  &QuotedMessage{ID: "other", Text: "baris satu\nbaris dua"},
+			message: "artinya apa?",
+			want:    []ChatMessage{{Role: RoleUser, Content: "> baris satu\n> baris dua\n\nartinya apa?"}},
+		},
+		{
+			name:    "QuotedBotNotRemembered",
+			quoted:  &QuotedMessage{ID: "old", FromBot: true, Text: "Jawaban lama."},
+			message: "jelaskan lagi",
+			want:    []ChatMessage{{Role: RoleAssistant, Content: "Jawaban lama."}, {Role: RoleUser, Content: "jelaskan lagi"}},
+		},
+		{
+			name:    "QuotedBotLastTurn",
+			history: []string{"siapa kamu?", "Aku Meow-AI."},
+			quoted:  &QuotedMessage{ID: "msg1", FromBot: true, Text: "Aku Meow-AI."},
+			message: "serius?",
+			want:    []ChatMessage{{Role: RoleUser, Content: "siapa kamu?"}, {Role: RoleAssistant, Content: "Aku Meow-AI."}, {Role: RoleUser, Content: "serius?"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupTestHistory(t)
+			setFlag(t, persona, "persona uji")
+			setFlag(t, maxTokens, 256)
+			addTestTurns(t, test.history...)
+			req := buildChatRequest(testChat, test.quoted, test.message)
+			want := append([]ChatMessage{{Role: RoleSystem, Content: "persona uji"}}, test.want...)
+			if fmt.Sprint(req.Messages) != fmt.Sprint(want) {
+				t.Errorf("got messages %q, want %q", req.Messages, want)
+			} else if req.MaxTokens != 256 {
+				t.Errorf("got max tokens %d, want 256", req.MaxTokens)
+			} else if req.Partial != nil {
+				t.Error("request shouldn't be streamed")
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var dailyTokenQuota = flag.Int("token-quota-daily", 20000, "AI tokens each user on the free tier can use per day (0 for unlimited)")
+var monthlyTokenQuota = flag.Int("token-quota-monthly", 300000, "AI tokens each user on the free tier can use per month (0 for unlimited)")
+
+// Features with usage tracked in the quota store.
+const (
+	FeatureTokens = "tokens"
+	FeatureImage  = "image"
+)
+
+// ErrQuotaExceeded is returned by UsageLimiter when the user has used up their AI tokens.
+var ErrQuotaExceeded = errors.New("AI usage quota exceeded")
+
+// quotas counts daily usage of limited features per user.
+var quotas *QuotaStore
+
+// QuotaStore keeps per-user daily usage counters in the database. Days are counted in the server's time zone.
+type QuotaStore struct {
+	db *sql.DB
+}
+
+// QuotaLimits are the daily and monthly limits of a feature. Zero means unlimited.
+type QuotaLimits struct {
+	Daily   int
+	Monthly int
+}
+
+const createQuotaTableQuery = `
+CREATE TABLE IF NOT EXISTS meow_quota (
+	user_jid TEXT    NOT NULL,
+	feature  TEXT    NOT NULL,
+	day      TEXT    NOT NULL,
+	count    INTEGER NOT NULL,
+	PRIMARY KEY (user_jid, feature, day)
+)`
+
+const createQuotaLimitsTableQuery = `
+CREATE TABLE IF NOT EXISTS meow_quota_limits (
+	user_jid TEXT    NOT NULL,
+	feature  TEXT    NOT NULL,
+	daily    INTEGER NOT NULL,
+	monthly  INTEGER NOT NULL,
+	PRIMARY KEY (user_jid, feature)
+)`
+
+// NewQuotaStore wraps an existing database connection and creates the quota tables if necessary.
+func NewQuotaStore(db *sql.DB) (*QuotaStore, error) {
+	for _, query := range []string{createQuotaTableQuery, createQuotaLimitsTableQuery} {
+		_, err := db.Exec(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create quota tables: %w", err)
+		}
+	}
+	return &QuotaStore{db: db}, nil
+}
+
+func today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+// Usage returns how much the user has used the feature today and this month.
+func (qs *QuotaStore) Usage(user types.JID, feature string) (daily, monthly int, err error) {
+	day := today()
+	rows, err := qs.db.Query(
+		`SELECT day, count FROM meow_quota WHERE user_jid=$1 AND feature=$2 AND day >= $3`,
+		user.ToNonAD().String(), feature, day[:len("2006-01")]+"-01",
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rowDay string
+		var count int
+		if err = rows.Scan(&rowDay, &count); err != nil {
+			return 0, 0, err
+		}
+		monthly += count
+		if rowDay == day {
+			daily += count
+		}
+	}
+	return daily, monthly, rows.Err()
+}
+
+// Add counts usage of the feature, e.g. the number of tokens used by a request.
+func (qs *QuotaStore) Add(user types.JID, feature string, amount int) error {
+	_, err := qs.db.Exec(`
+		INSERT INTO meow_quota (user_jid, feature, day, count) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_jid, feature, day) DO UPDATE SET count=meow_quota.count+excluded.count
+	`, user.ToNonAD().String(), feature, today(), amount)
+	if err != nil {
+		return err
+	}
+	_, err = qs.db.Exec(`DELETE FROM meow_quota WHERE day < $1`, time.Now().AddDate(0, -2, 0).Format("2006-01-02"))
+	return err
+}
+
+// Take uses one unit of the user's daily quota for the feature. It returns false without counting anything
+// if the limit has already been reached. The check and the update are a single statement, so concurrent
+// requests can't exceed the limit.
+func (qs *QuotaStore) Take(user types.JID, feature string, limit int) (bool, error) {
+	res, err := qs.db.Exec(`
+		INSERT INTO meow_quota (user_jid, feature, day, count) SELECT $1, $2, $3, 1 WHERE $4 > 0
+		ON CONFLICT (user_jid, feature, day) DO UPDATE SET count=meow_quota.count+1 WHERE meow_quota.count < $4
+	`, user.ToNonAD().String(), feature, today(), limit)
+	if err != nil {
+		return false, err
+	}
+	taken, err := res.RowsAffected()
+	return taken > 0, err
+}
+
+// Refund gives back a unit taken with Take, e.g. if the feature failed.
+func (qs *QuotaStore) Refund(user types.JID, feature string) error {
+	_, err := qs.db.Exec(
+		`UPDATE meow_quota SET count=count-1 WHERE user_jid=$1 AND feature=$2 AND day=$3 AND count > 0`,
+		user.ToNonAD().String(), feature, today(),
+	)
+	return err
+}
+
+// Limits returns the limits of the feature for the user. Limits that haven't been set for the user
+// (or are negative) are taken from the defaults.
+func (qs *QuotaStore) Limits(user types.JID, feature string, defaults QuotaLimits) (QuotaLimits, error) {
+	limits := QuotaLimits{Daily: -1, Monthly: -1}
+	err := qs.db.QueryRow(
+		`SELECT daily, monthly FROM meow_quota_limits WHERE user_jid=$1 AND feature=$2`, user.ToNonAD().String(), feature,
+	).Scan(&limits.Daily, &limits.Monthly)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return defaults, err
+	}
+	if limits.Daily < 0 {
+		limits.Daily = defaults.Daily
+	}
+	if limits.Monthly < 0 {
+		limits.Monthly = defaults.Monthly
+	}
+	return limits, nil
+}
+
+// SetLimits overrides the limits of the feature for the user. Negative values use the defaults.
+func (qs *QuotaStore) SetLimits(user types.JID, feature string, limits QuotaLimits) error {
+	_, err := qs.db.Exec(`
+		INSERT INTO meow_quota_limits (user_jid, feature, daily, monthly) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_jid, feature) DO UPDATE SET daily=excluded.daily, monthly=excluded.monthly
+	`, user.ToNonAD().String(), feature, limits.Daily, limits.Monthly)
+	return err
+}
+
+type aiUserContextKey struct{}
+
+// aiUser is the user an AI request is made for.
+type aiUser struct {
+	Sender types.JID
+	Chat   types.JID
+	// Exempt users (the bot owners) aren't rate limited and have no quota.
+	Exempt bool
+}
+
+// aiContext returns a context for AI requests made to answer the given message, so that the
+// usage can be limited and accounted to the sender.
+func aiContext(ctx context.Context, evt *events.Message) context.Context {
+	return context.WithValue(ctx, aiUserContextKey{}, aiUser{
+		Sender: evt.Info.Sender,
+		Chat:   evt.Info.Chat,
+		Exempt: evt.Info.IsFromMe || isOwner(evt.Info.Sender),
+	})
+}
+
+// UsageLimiter enforces the access policy, rate limits AI requests per user and per chat, and enforces
+// the token quotas and models of the user's tier. Requests without a user in the context (see aiContext)
+// are passed through as-is.
+type UsageLimiter struct {
+	Completer
+	Users *RateLimiter
+	Chats *RateLimiter
+}
+
+func (ul *UsageLimiter) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	user, ok := ctx.Value(aiUserContextKey{}).(aiUser)
+	if !ok {
+		return ul.Completer.Complete(ctx, req)
+	}
+	if !user.Exempt {
+		tier, err := accessPolicy.Check(user.Sender)
+		if errors.Is(err, ErrBlocked) || errors.Is(err, ErrNotActivated) {
+			return nil, err
+		} else if err != nil {
+			log.Warnf("Failed to check access of %s, using the free tier: %v", user.Sender, err)
+			tier = builtinTier(TierFree)
+		}
+		if !ul.Users.Allow(user.Sender.ToNonAD().String()) || !ul.Chats.Allow(user.Chat.String()) {
+			return nil, ErrRateLimited
+		}
+		err = checkTokenQuota(user.Sender, tier)
+		if err != nil {
+			return nil, err
+		}
+		if req.Model == "" {
+			req.Model = tier.Model
+		}
+	}
+	completion, err := ul.Completer.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	tokens := completion.Tokens
+	if tokens == 0 {
+		for _, msg := range req.Messages {
+			tokens += estimateTokens(msg.Content)
+		}
+		tokens += estimateTokens(completion.Text)
+	}
+	err = quotas.Add(user.Sender, FeatureTokens, tokens)
+	if err != nil {
+		log.Warnf("Failed to record AI usage of %s: %v", user.Sender, err)
+	}
+	return completion, nil
+}
+
+// checkTokenQuota returns ErrQuotaExceeded if the user has used up their daily or monthly tokens.
+func checkTokenQuota(user types.JID, tier *Tier) error {
+	limits, err := quotas.Limits(user, FeatureTokens, tier.TokenLimits())
+	if err != nil {
+		log.Warnf("Failed to get quota of %s: %v", user, err)
+		return nil
+	}
+	daily, monthly, err := quotas.Usage(user, FeatureTokens)
+	if err != nil {
+		log.Warnf("Failed to get AI usage of %s: %v", user, err)
+		return nil
+	} else if (limits.Daily > 0 && daily >= limits.Daily) || (limits.Monthly > 0 && monthly >= limits.Monthly) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// withUsageLimits wraps a Completer with rate limits and quotas configured from flags.
+func withUsageLimits(completer Completer) Completer {
+	return &UsageLimiter{
+		Completer: completer,
+		Users:     NewRateLimiter(*userRateLimit),
+		Chats:     NewRateLimiter(*chatRateLimit),
+	}
+}
+
+func formatLimit(used, limit int) string {
+	if limit == 0 {
+		return fmt.Sprintf("%d (tanpa batas)", used)
+	}
+	return fmt.Sprintf("%d / %d", used, limit)
+}
+
+func init() {
+	registerCommand(&Command{
+		Name: "quota",
+		Args: []CommandArg{
+			{Name: "nomor", Type: ArgJID},
+			{Name: "harian", Type: ArgInt, Optional: true},
+			{Name: "bulanan", Type: ArgInt, Optional: true},
+		},
+		Help:  "Lihat pemakaian token AI pengguna, atau ubah batas harian/bulanannya (0 tanpa batas, -1 kembali ke batas paketnya).",
+		Level: LevelOwner,
+		Handler: func(ctx *CommandContext) {
+			user := ctx.JID("nomor")
+			if ctx.Has("harian") {
+				current, err := quotas.Limits(user, FeatureTokens, QuotaLimits{Daily: -1, Monthly: -1})
+				if err != nil {
+					log.Errorf("Failed to get quota of %s: %v", user, err)
+					return
+				}
+				current.Daily = ctx.Int("harian")
+				if ctx.Has("bulanan") {
+					current.Monthly = ctx.Int("bulanan")
+				}
+				err = quotas.SetLimits(user, FeatureTokens, current)
+				if err != nil {
+					log.Errorf("Failed to set quota of %s: %v", user, err)
+					return
+				}
+			}
+			tier := userTier(user)
+			limits, err := quotas.Limits(user, FeatureTokens, tier.TokenLimits())
+			if err != nil {
+				log.Errorf("Failed to get quota of %s: %v", user, err)
+				return
+			}
+			daily, monthly, err := quotas.Usage(user, FeatureTokens)
+			if err != nil {
+				log.Errorf("Failed to get AI usage of %s: %v", user, err)
+				return
+			}
+			images, _, err := quotas.Usage(user, FeatureImage)
+			if err != nil {
+				log.Errorf("Failed to get image usage of %s: %v", user, err)
+				return
+			}
+			var reply strings.Builder
+			fmt.Fprintf(&reply, "*Pemakaian %s* (paket %s)\n\n", user.User, tier.Name)
+			fmt.Fprintf(&reply, "Token hari ini: %s\n", formatLimit(daily, limits.Daily))
+			fmt.Fprintf(&reply, "Token bulan ini: %s\n", formatLimit(monthly, limits.Monthly))
+			fmt.Fprintf(&reply, "Gambar hari ini: %s", formatLimit(images, tier.DailyImages))
+			ctx.Reply(reply.String())
+		},
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// QuotedMessage is the message that a message replies to.
+type QuotedMessage struct {
+	ID     types.MessageID
+	Sender types.JID
+	// FromBot is true if the quoted message was sent by the bot itself.
+	FromBot bool
+	Text    string
+}
+
+// messageContextInfo returns the context info of any message type that can reply to another message.
+func messageContextInfo(msg *waProto.Message) *waProto.ContextInfo {
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetContextInfo()
+	case msg.GetDocumentWithCaptionMessage() != nil:
+		return msg.GetDocumentWithCaptionMessage().GetMessage().GetDocumentMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetContextInfo()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage().GetContextInfo()
+	}
+	return nil
+}
+
+// messageText returns a text description of a message for the AI prompt, e.g. the caption of an image
+// prefixed with [gambar] or the question and options of a poll. It's empty if the message has no text.
+func messageText(msg *waProto.Message) string {
+	if inner := msg.GetDocumentWithCaptionMessage().GetMessage(); inner != nil {
+		msg = inner
+	}
+	switch {
+	case msg.GetConversation() != "":
+		return msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		return strings.TrimSpace("[gambar] " + msg.GetImageMessage().GetCaption())
+	case msg.GetVideoMessage() != nil:
+		return strings.TrimSpace("[video] " + msg.GetVideoMessage().GetCaption())
+	case msg.GetDocumentMessage() != nil:
+		doc := msg.GetDocumentMessage()
+		return strings.TrimSpace(fmt.Sprintf("[dokumen %s] %s", doc.GetFileName(), doc.GetCaption()))
+	case msg.GetAudioMessage() != nil:
+		return "[pesan suara]"
+	case msg.GetStickerMessage() != nil:
+		return "[stiker]"
+	case msg.GetPollCreationMessage() != nil || msg.GetPollCreationMessageV2() != nil:
+		poll := msg.GetPollCreationMessage()
+		if poll == nil {
+			poll = msg.GetPollCreationMessageV2()
+		}
+		var text strings.Builder
+		fmt.Fprintf(&text, "[polling] %s", poll.GetName())
+		for _, option := range poll.GetOptions() {
+			fmt.Fprintf(&text, "\n- %s", option.GetOptionName())
+		}
+		return text.String()
+	case msg.GetLocationMessage() != nil:
+		loc := msg.GetLocationMessage()
+		return strings.TrimSpace(fmt.Sprintf("[lokasi] %s %s", loc.GetName(), loc.GetAddress()))
+	case msg.GetContactMessage() != nil:
+		return "[kontak] " + msg.GetContactMessage().GetDisplayName()
+	}
+	return ""
+}
+
+// quotedMessage returns the message that evt replies to, or nil if it isn't a reply. The text embedded in
+// the reply may be shortened by WhatsApp, so the full text is taken from the history if it's remembered.
+func quotedMessage(evt *events.Message) *QuotedMessage {
+	ctxInfo := messageContextInfo(evt.Message)
+	if ctxInfo.GetQuotedMessage() == nil {
+		return nil
+	}
+	quoted := &QuotedMessage{
+		ID:   ctxInfo.GetStanzaId(),
+		Text: messageText(ctxInfo.GetQuotedMessage()),
+	}
+	if sender, err := types.ParseJID(ctxInfo.GetParticipant()); err == nil {
+		quoted.Sender = sender
+		quoted.FromBot = cli.Store.ID != nil && sender.User == cli.Store.ID.User
+	}
+	if quoted.ID != "" {
+		turn, err := history.Get(evt.Info.Chat, quoted.ID)
+		if err != nil {
+			log.Warnf("Failed to get quoted message %s from history: %v", quoted.ID, err)
+		} else if turn != nil {
+			quoted.FromBot = quoted.FromBot || turn.Role == RoleAssistant
+			if len(turn.Content) > len(quoted.Text) {
+				quoted.Text = turn.Content
+			}
+		}
+	}
+	return quoted
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"sync"
+	"time"
+)
+
+var userRateLimit = flag.Int("rate-limit-user", 6, "AI replies per minute for each user (0 for unlimited)")
+var chatRateLimit = flag.Int("rate-limit-chat", 20, "AI replies per minute in each chat (0 for unlimited)")
+var voiceRateLimit = flag.Int("rate-limit-voice", 3, "Voice notes transcribed per minute for each user (0 for unlimited)")
+
+// ErrRateLimited is returned by UsageLimiter when a user or chat sends messages too quickly.
+var ErrRateLimited = errors.New("too many AI requests")
+
+// maxIdleBuckets is the number of buckets after which full (idle) buckets are dropped.
+const maxIdleBuckets = 10000
+
+// RateLimiter is a set of token buckets identified by string keys.
+type RateLimiter struct {
+	// Rate is the number of tokens added per second, zero disables the limiter.
+	Rate float64
+	// Burst is the size of each bucket.
+	Burst float64
+
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter that allows the given number of events per minute, all of which
+// can be used at once.
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return &RateLimiter{
+		Rate:    float64(perMinute) / 60,
+		Burst:   float64(perMinute),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (rl *RateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rl.Rate
+	if bucket.tokens > rl.Burst {
+		bucket.tokens = rl.Burst
+	}
+	bucket.last = now
+}
+
+// Allow takes a token from the bucket of the key and returns false if the bucket is empty.
+func (rl *RateLimiter) Allow(key string) bool {
+	if rl.Rate <= 0 {
+		return true
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	now := time.Now()
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		if len(rl.buckets) >= maxIdleBuckets {
+			rl.dropIdle(now)
+		}
+		bucket = &tokenBucket{tokens: rl.Burst, last: now}
+		rl.buckets[key] = bucket
+	}
+	rl.refill(bucket, now)
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+func (rl *RateLimiter) dropIdle(now time.Time) {
+	for key, bucket := range rl.buckets {
+		rl.refill(bucket, now)
+		if bucket.tokens >= rl.Burst {
+			delete(rl.buckets, key)
+		}
+	}
+}
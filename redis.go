@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisTimeout limits the time for connecting to Redis and for each command, so that a Redis server that
+// stopped responding doesn't block the caller forever. Blocking commands get this on top of their own timeout.
+const redisTimeout = 10 * time.Second
+
+// newRedisClient connects to a redis://[:password@]host[:port][/db] URL. Plain host:port addresses are also accepted.
+func newRedisClient(rawURL string) (*redis.Client, error) {
+	opts := &redis.Options{Addr: rawURL}
+	if strings.Contains(rawURL, "://") {
+		var err error
+		opts, err = redis.ParseURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis URL: %w", err)
+		}
+	}
+	opts.DialTimeout = redisTimeout
+	opts.ReadTimeout = redisTimeout
+	opts.WriteTimeout = redisTimeout
+	return redis.NewClient(opts), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// sendMessage sends a message and logs the result, so that callers (including fire-and-forget
+// goroutines) don't need to handle send errors themselves.
+func sendMessage(to types.JID, msg *waProto.Message) (whatsmeow.SendResponse, error) {
+	resp, err := cli.SendMessage(context.Background(), to, msg)
+	if err != nil {
+		log.Errorf("Failed to send message to %s: %v", to, err)
+	} else {
+		log.Debugf("Sent message %s to %s (server timestamp: %s)", resp.ID, to, resp.Timestamp)
+	}
+	return resp, err
+}
+
+// quoteContext returns the context info for replying to the given message.
+func quoteContext(evt *events.Message) *waProto.ContextInfo {
+	return &waProto.ContextInfo{
+		StanzaID:      proto.String(evt.Info.ID),
+		Participant:   proto.String(evt.Info.Sender.String()),
+		QuotedMessage: evt.Message,
+	}
+}
+
+// replyText sends a plain text message to the chat of the given message.
+func replyText(evt *events.Message, text string) (whatsmeow.SendResponse, error) {
+	return sendMessage(evt.Info.Chat, &waProto.Message{Conversation: proto.String(text)})
+}
+
+// replyQuote sends a text message quoting the given message.
+func replyQuote(evt *events.Message, text string) (whatsmeow.SendResponse, error) {
+	return sendMessage(evt.Info.Chat, &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String(text),
+			ContextInfo: quoteContext(evt),
+		},
+	})
+}
+
+// replyMentions sends a text message quoting the given message and mentioning the given users.
+// The text should contain @<phone number> for each mentioned user.
+func replyMentions(evt *events.Message, text string, mentions ...types.JID) (whatsmeow.SendResponse, error) {
+	ctxInfo := quoteContext(evt)
+	for _, jid := range mentions {
+		ctxInfo.MentionedJID = append(ctxInfo.MentionedJID, jid.ToNonAD().String())
+	}
+	return sendMessage(evt.Info.Chat, &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String(text),
+			ContextInfo: ctxInfo,
+		},
+	})
+}
+
+// buildMediaMessage uploads the media and builds a message of the matching type.
+func buildMediaMessage(data []byte, mediaType whatsmeow.MediaType, mimetype, caption string, ctxInfo *waProto.ContextInfo) (*waProto.Message, error) {
+	uploaded, err := cli.Upload(context.Background(), data, mediaType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload %s: %w", mediaType, err)
+	}
+	if mimetype == "" {
+		mimetype = http.DetectContentType(data)
+	}
+	switch mediaType {
+	case whatsmeow.MediaImage:
+		return &waProto.Message{ImageMessage: &waProto.ImageMessage{
+			Caption:       proto.String(caption),
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(mimetype),
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(data))),
+			ContextInfo:   ctxInfo,
+		}}, nil
+	case whatsmeow.MediaVideo:
+		return &waProto.Message{VideoMessage: &waProto.VideoMessage{
+			Caption:       proto.String(caption),
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(mimetype),
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(data))),
+			ContextInfo:   ctxInfo,
+		}}, nil
+	case whatsmeow.MediaAudio:
+		return &waProto.Message{AudioMessage: &waProto.AudioMessage{
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(mimetype),
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(data))),
+			ContextInfo:   ctxInfo,
+		}}, nil
+	default:
+		return &waProto.Message{DocumentMessage: &waProto.DocumentMessage{
+			Caption:       proto.String(caption),
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(mimetype),
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(data))),
+			ContextInfo:   ctxInfo,
+		}}, nil
+	}
+}
+
+// replyMedia uploads the media and sends it quoting the given message.
+func replyMedia(evt *events.Message, data []byte, mediaType whatsmeow.MediaType, mimetype, caption string) (whatsmeow.SendResponse, error) {
+	msg, err := buildMediaMessage(data, mediaType, mimetype, caption, quoteContext(evt))
+	if err != nil {
+		log.Errorf("Failed to prepare reply to %s: %v", evt.Info.ID, err)
+		return whatsmeow.SendResponse{}, err
+	}
+	return sendMessage(evt.Info.Chat, msg)
+}
+
+// reactTo reacts to the given message with an emoji. An empty emoji removes the reaction.
+func reactTo(evt *events.Message, emoji string) (whatsmeow.SendResponse, error) {
+	key := &waProto.MessageKey{
+		RemoteJID: proto.String(evt.Info.Chat.String()),
+		FromMe:    proto.Bool(evt.Info.IsFromMe),
+		ID:        proto.String(evt.Info.ID),
+	}
+	if evt.Info.IsGroup && !evt.Info.IsFromMe {
+		key.Participant = proto.String(evt.Info.Sender.String())
+	}
+	return sendMessage(evt.Info.Chat, &waProto.Message{ReactionMessage: &waProto.ReactionMessage{
+		Key:               key,
+		Text:              proto.String(emoji),
+		SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
+	}})
+}
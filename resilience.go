@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var llmTimeout = flag.Duration("llm-timeout", 60*time.Second, "Timeout for a single AI backend request")
+var llmRetries = flag.Int("llm-retries", 3, "Number of attempts for transient AI backend errors")
+var breakerThreshold = flag.Int("llm-breaker-threshold", 5, "Consecutive AI backend failures before requests are stopped temporarily")
+var breakerCooldown = flag.Duration("llm-breaker-cooldown", time.Minute, "How long to stop sending requests to a failing AI backend")
+var locale = flag.String("locale", "id", "Language of the bot's own messages (id or en)")
+
+// ErrCircuitOpen is returned by CircuitBreaker when the backend has been failing and is not being called.
+var ErrCircuitOpen = errors.New("AI backend is temporarily disabled after repeated failures")
+
+// APIError is returned by HTTP-based backends when the server responds with a non-200 status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *APIError) Error() string {
+	if err.Message != "" {
+		return fmt.Sprintf("unexpected status %d: %s", err.StatusCode, err.Message)
+	}
+	return fmt.Sprintf("unexpected status %d", err.StatusCode)
+}
+
+// isTransient returns true if the request that caused the error can be retried.
+func isTransient(err error) bool {
+	var apiErr *APIError
+	var netErr net.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	} else if errors.As(err, &netErr) {
+		return true
+	}
+	return false
+}
+
+// RetryingCompleter retries transient errors of the wrapped Completer with exponential backoff.
+type RetryingCompleter struct {
+	Completer
+	Attempts  int
+	BaseDelay time.Duration
+}
+
+func (rc *RetryingCompleter) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	// Text that has already been streamed may have been sent to the user, so retrying would repeat it.
+	streamed := false
+	if partial := req.Partial; partial != nil {
+		req.Partial = func(text string) {
+			streamed = true
+			partial(text)
+		}
+	}
+	var completion *Completion
+	err := retryTransient(ctx, "AI backend request", rc.Attempts, rc.BaseDelay, *llmTimeout, func(ctx context.Context) (bool, error) {
+		var err error
+		completion, err = rc.Completer.Complete(ctx, req)
+		return !streamed, err
+	})
+	return completion, err
+}
+
+// RetryingTranscriber retries transient errors of the wrapped Transcriber with exponential backoff.
+type RetryingTranscriber struct {
+	Transcriber
+	Attempts  int
+	BaseDelay time.Duration
+}
+
+func (rt *RetryingTranscriber) Transcribe(ctx context.Context, audio []byte, mimetype string) (string, error) {
+	var text string
+	err := retryTransient(ctx, "Transcription request", rt.Attempts, rt.BaseDelay, voiceTimeout, func(ctx context.Context) (bool, error) {
+		var err error
+		text, err = rt.Transcriber.Transcribe(ctx, audio, mimetype)
+		return true, err
+	})
+	return text, err
+}
+
+// retryTransient calls fn with a timeout until it succeeds, fails with an error that isn't transient, or
+// has been called attempts times, waiting with exponential backoff in between. fn also returns whether
+// it may be called again after failing.
+func retryTransient(ctx context.Context, what string, attempts int, delay, timeout time.Duration, fn func(ctx context.Context) (bool, error)) error {
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		retryable, err := fn(attemptCtx)
+		cancel()
+		if err == nil || attempt >= attempts || !isTransient(err) || ctx.Err() != nil || !retryable {
+			return err
+		}
+		wait := delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+		log.Warnf("%s failed (attempt %d/%d), retrying in %s: %v", what, attempt, attempts, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// CircuitBreaker stops calling the wrapped Completer after too many consecutive transient failures, and
+// lets a single request through after the cooldown to check if the backend has recovered.
+type CircuitBreaker struct {
+	Completer
+	Threshold int
+	Cooldown  time.Duration
+
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow checks if a request may be sent. probe is true if the request is the single one let through
+// to check if the backend has recovered, in which case endProbe must be called when it's done.
+func (cb *CircuitBreaker) allow() (ok, probe bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.failures < cb.Threshold {
+		return true, false
+	} else if cb.probing || time.Now().Before(cb.openUntil) {
+		return false, false
+	}
+	cb.probing = true
+	return true, true
+}
+
+// endProbe lets the next request through as a probe if the current probe didn't record a result,
+// e.g. because it was canceled.
+func (cb *CircuitBreaker) endProbe() {
+	cb.lock.Lock()
+	cb.probing = false
+	cb.lock.Unlock()
+}
+
+func (cb *CircuitBreaker) record(err error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if err == nil {
+		if cb.failures >= cb.Threshold {
+			log.Infof("AI backend recovered, closing circuit breaker")
+		}
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.failures >= cb.Threshold {
+		cb.openUntil = time.Now().Add(cb.Cooldown)
+		log.Warnf("AI backend failed %d times in a row, pausing requests until %s", cb.failures, cb.openUntil.Format("15:04:05"))
+	}
+}
+
+func (cb *CircuitBreaker) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	ok, probe := cb.allow()
+	if !ok {
+		return nil, ErrCircuitOpen
+	} else if probe {
+		defer cb.endProbe()
+	}
+	completion, err := cb.Completer.Complete(ctx, req)
+	// Only transient errors mean the backend is failing. Canceled requests and errors caused by the request
+	// itself (e.g. a prompt that's too long) say nothing about the backend, so they're neither a success
+	// nor a failure.
+	if err == nil || (isTransient(err) && !errors.Is(err, context.Canceled)) {
+		cb.record(err)
+	}
+	return completion, err
+}
+
+// withResilience wraps a Completer with retries and a circuit breaker configured from flags.
+func withResilience(completer Completer) Completer {
+	return &CircuitBreaker{
+		Completer: &RetryingCompleter{
+			Completer: completer,
+			Attempts:  *llmRetries,
+			BaseDelay: time.Second,
+		},
+		Threshold: *breakerThreshold,
+		Cooldown:  *breakerCooldown,
+	}
+}
+
+// Reasons for sending a fallback message instead of an AI reply.
+const (
+	fallbackError       = "error"
+	fallbackCircuitOpen = "circuit-open"
+	fallbackRateLimited = "rate-limited"
+	fallbackQuota       = "quota"
+	fallbackNotActive   = "not-activated"
+	fallbackBusy        = "busy"
+)
+
+var fallbackMessages = map[string]map[string]string{
+	"id": {
+		fallbackError:       "Maaf, AI sedang mengalami gangguan dan belum bisa menjawab pesan ini 🙏. Silahkan coba lagi beberapa saat lagi.",
+		fallbackCircuitOpen: "Maaf, AI sedang istirahat sebentar karena terlalu banyak gangguan 🙏. Silahkan coba lagi dalam beberapa menit.",
+		fallbackRateLimited: "Pelan-pelan ya, pesanmu terlalu banyak dalam waktu singkat 🙏. Silahkan coba lagi sebentar lagi.",
+		fallbackQuota:       "Maaf, jatah pemakaian AI kamu sudah habis 🙏. Silahkan coba lagi besok.",
+		fallbackNotActive:   "Bot ini hanya untuk pengguna terdaftar 🙏. Jika punya kode undangan, kirim !aktivasi <kode>.",
+		fallbackBusy:        "Maaf, bot sedang menerima terlalu banyak pesan 🙏. Silahkan kirim lagi beberapa saat lagi.",
+	},
+	"en": {
+		fallbackError:       "Sorry, the AI is having trouble and couldn't answer this message 🙏. Please try again in a moment.",
+		fallbackCircuitOpen: "Sorry, the AI is taking a short break after too many errors 🙏. Please try again in a few minutes.",
+		fallbackRateLimited: "Slow down a bit, you've sent too many messages in a short time 🙏. Please try again in a moment.",
+		fallbackQuota:       "Sorry, you've used up your AI quota 🙏. Please try again tomorrow.",
+		fallbackNotActive:   "This bot is only available to registered users 🙏. If you have an invite code, send !activate <code>.",
+		fallbackBusy:        "Sorry, the bot is receiving too many messages right now 🙏. Please send yours again in a moment.",
+	},
+}
+
+// sendFallback tells the user that their message couldn't be answered, quoting the original message.
+// Blocked users don't get any reply.
+func sendFallback(evt *events.Message, cause error) {
+	if errors.Is(cause, ErrBlocked) {
+		log.Debugf("Not answering %s from blocked user %s", evt.Info.ID, evt.Info.Sender)
+		return
+	}
+	messages, ok := fallbackMessages[*locale]
+	if !ok {
+		messages = fallbackMessages["id"]
+	}
+	reason := fallbackError
+	switch {
+	case errors.Is(cause, ErrCircuitOpen):
+		reason = fallbackCircuitOpen
+	case errors.Is(cause, ErrRateLimited):
+		reason = fallbackRateLimited
+	case errors.Is(cause, ErrQuotaExceeded):
+		reason = fallbackQuota
+	case errors.Is(cause, ErrNotActivated):
+		reason = fallbackNotActive
+	case errors.Is(cause, ErrQueueFull):
+		reason = fallbackBusy
+	}
+	replyQuote(evt, messages[reason])
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// flakyTranscriber fails with the given errors before succeeding.
+type flakyTranscriber struct {
+	errs  []error
+	calls int
+}
+
+func (ft *flakyTranscriber) Transcribe(context.Context, []byte, string) (string, error) {
+	ft.calls++
+	if ft.calls <= len(ft.errs) {
+		return "", ft.errs[ft.calls-1]
+	}
+	return "halo", nil
+}
+
+func TestRetryingTranscriber(t *testing.T) {
+	log = waLog.Noop
+	unavailable := &APIError{StatusCode: http.StatusServiceUnavailable}
+	badRequest := &APIError{StatusCode: http.StatusBadRequest}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{"success", nil, nil, 1},
+		{"transient", []error{unavailable}, nil, 2},
+		{"not transient", []error{badRequest}, badRequest, 1},
+		{"attempts used up", []error{unavailable, unavailable, unavailable}, unavailable, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flaky := &flakyTranscriber{errs: test.errs}
+			rt := &RetryingTranscriber{Transcriber: flaky, Attempts: 3, BaseDelay: time.Millisecond}
+			text, err := rt.Transcribe(context.Background(), nil, "audio/ogg")
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got error %v, want %v", err, test.wantErr)
+			} else if err == nil && text != "halo" {
+				t.Errorf("got text %q, want %q", text, "halo")
+			}
+			if flaky.calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", flaky.calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+// errorCompleter fails every request with err, or succeeds if err is nil.
+type errorCompleter struct {
+	err error
+}
+
+func (ec *errorCompleter) Complete(context.Context, CompletionRequest) (*Completion, error) {
+	if ec.err != nil {
+		return nil, ec.err
+	}
+	return &Completion{Text: "ok", FinishReason: "stop"}, nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	log = waLog.Noop
+	unavailable := &APIError{StatusCode: http.StatusServiceUnavailable}
+	badRequest := &APIError{StatusCode: http.StatusBadRequest, Message: "context length exceeded"}
+	backend := &errorCompleter{}
+	cb := &CircuitBreaker{Completer: backend, Threshold: 2, Cooldown: time.Hour}
+	complete := func() error {
+		_, err := cb.Complete(context.Background(), CompletionRequest{})
+		return err
+	}
+
+	backend.err = badRequest
+	for i := 0; i < 5; i++ {
+		if err := complete(); !errors.Is(err, badRequest) {
+			t.Fatalf("request %d with a bad prompt: got %v, want %v", i, err, badRequest)
+		}
+	}
+	backend.err = unavailable
+	for i := 0; i < 2; i++ {
+		if err := complete(); !errors.Is(err, unavailable) {
+			t.Fatalf("request %d to failing backend: got %v, want %v", i, err, unavailable)
+		}
+	}
+	if err := complete(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v after transient failures, want %v", err, ErrCircuitOpen)
+	}
+
+	// A probe that fails because of the request doesn't keep the breaker open or closed.
+	cb.openUntil = time.Now()
+	backend.err = badRequest
+	if err := complete(); !errors.Is(err, badRequest) {
+		t.Fatalf("probe with a bad prompt: got %v, want %v", err, badRequest)
+	}
+	backend.err = nil
+	if err := complete(); err != nil {
+		t.Fatalf("probe after the backend recovered: got %v", err)
+	}
+	if err := complete(); err != nil {
+		t.Fatalf("request after the breaker closed: got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var rulesFile = flag.String("rules", "rules.json", "JSON file with keyword auto-responder rules, reloaded automatically when changed")
+
+// Ways a rule trigger can match a message.
+const (
+	MatchExact    = "exact"
+	MatchPrefix   = "prefix"
+	MatchContains = "contains"
+	MatchRegex    = "regex"
+)
+
+// Chats in which a rule applies.
+const (
+	ScopeDM    = "dm"
+	ScopeGroup = "group"
+	ScopeSelf  = "self"
+)
+
+// Types of rule responses.
+const (
+	ResponseText     = "text"
+	ResponseQuote    = "quote"
+	ResponsePoll     = "poll"
+	ResponseImage    = "image"
+	ResponseReaction = "reaction"
+)
+
+// RuleResponse describes what the bot sends when a rule matches.
+type RuleResponse struct {
+	Type string `json:"type"`
+	// Text is the message for text and quote responses and the question for polls.
+	Text string `json:"text,omitempty"`
+	// Options and MaxAnswers are used for polls.
+	Options    []string `json:"options,omitempty"`
+	MaxAnswers int      `json:"max_answers,omitempty"`
+	// Image is the path of the file to send for image responses, Text is used as the caption.
+	Image string `json:"image,omitempty"`
+	// Emoji is the reaction to send.
+	Emoji string `json:"emoji,omitempty"`
+}
+
+// Rule is a single auto-responder entry. Triggers are matched case-insensitively.
+type Rule struct {
+	Name     string       `json:"name"`
+	Match    string       `json:"match"`
+	Triggers []string     `json:"triggers"`
+	Scope    []string     `json:"scope,omitempty"`
+	Response RuleResponse `json:"response"`
+
+	patterns []*regexp.Regexp
+}
+
+func (rule *Rule) compile() error {
+	switch rule.Match {
+	case "":
+		rule.Match = MatchExact
+	case MatchExact, MatchPrefix, MatchContains:
+	case MatchRegex:
+		for _, trigger := range rule.Triggers {
+			pattern, err := regexp.Compile("(?i)" + trigger)
+			if err != nil {
+				return fmt.Errorf("invalid regex in rule %q: %w", rule.Name, err)
+			}
+			rule.patterns = append(rule.patterns, pattern)
+		}
+	default:
+		return fmt.Errorf("unknown match type %q in rule %q", rule.Match, rule.Name)
+	}
+	if len(rule.Scope) == 0 {
+		rule.Scope = []string{ScopeDM}
+	}
+	for i, trigger := range rule.Triggers {
+		rule.Triggers[i] = strings.ToLower(trigger)
+	}
+	return nil
+}
+
+func (rule *Rule) matches(text, scope string) bool {
+	inScope := false
+	for _, s := range rule.Scope {
+		inScope = inScope || s == scope
+	}
+	if !inScope {
+		return false
+	}
+	lower := strings.ToLower(strings.TrimSpace(text))
+	for i, trigger := range rule.Triggers {
+		switch rule.Match {
+		case MatchExact:
+			if lower == trigger {
+				return true
+			}
+		case MatchPrefix:
+			if strings.HasPrefix(lower, trigger) {
+				return true
+			}
+		case MatchContains:
+			if strings.Contains(lower, trigger) {
+				return true
+			}
+		case MatchRegex:
+			if rule.patterns[i].MatchString(text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// messageScope returns the rule scope that applies to a message.
+func messageScope(evt *events.Message) string {
+	if evt.Info.IsFromMe {
+		return ScopeSelf
+	} else if evt.Info.IsGroup {
+		return ScopeGroup
+	}
+	return ScopeDM
+}
+
+// AutoResponder holds the rules loaded from the rules file.
+type AutoResponder struct {
+	lock    sync.RWMutex
+	rules   []*Rule
+	modTime time.Time
+}
+
+// autoResponder answers messages that match the rules file before they reach the AI.
+var autoResponder = &AutoResponder{}
+
+// Load reads the rules file if it has changed since the last load. Invalid files are
+// rejected and the previously loaded rules are kept.
+func (ar *AutoResponder) Load(path string) error {
+	stat, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	ar.lock.RLock()
+	unchanged := stat.ModTime().Equal(ar.modTime)
+	ar.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rules []*Rule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, rule := range rules {
+		if err = rule.compile(); err != nil {
+			return err
+		}
+	}
+	ar.lock.Lock()
+	ar.rules = rules
+	ar.modTime = stat.ModTime()
+	ar.lock.Unlock()
+	log.Infof("Loaded %d auto-responder rules from %s", len(rules), path)
+	return nil
+}
+
+// Watch reloads the rules file whenever it changes.
+func (ar *AutoResponder) Watch(path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		err := ar.Load(path)
+		if err != nil {
+			log.Errorf("Failed to reload auto-responder rules: %v", err)
+		}
+	}
+}
+
+// Match returns the first rule matching the message text, or nil if there isn't one.
+func (ar *AutoResponder) Match(evt *events.Message, text string) *Rule {
+	scope := messageScope(evt)
+	ar.lock.RLock()
+	defer ar.lock.RUnlock()
+	for _, rule := range ar.rules {
+		if rule.matches(text, scope) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Respond sends the response of the rule to the message.
+func (rule *Rule) Respond(evt *events.Message) {
+	resp := rule.Response
+	switch resp.Type {
+	case ResponseText:
+		replyText(evt, resp.Text)
+	case ResponseQuote, "":
+		replyQuote(evt, resp.Text)
+	case ResponsePoll:
+		maxAnswers := resp.MaxAnswers
+		if maxAnswers == 0 {
+			maxAnswers = 1
+		}
+		sendMessage(evt.Info.Chat, cli.BuildPollCreation(resp.Text, resp.Options, maxAnswers))
+	case ResponseImage:
+		data, err := os.ReadFile(resp.Image)
+		if err != nil {
+			log.Errorf("Failed to read image of rule %q: %v", rule.Name, err)
+			return
+		}
+		msg, err := buildMediaMessage(data, whatsmeow.MediaImage, "", resp.Text, nil)
+		if err != nil {
+			log.Errorf("Failed to prepare image of rule %q: %v", rule.Name, err)
+			return
+		}
+		sendMessage(evt.Info.Chat, msg)
+	case ResponseReaction:
+		reactTo(evt, resp.Emoji)
+	default:
+		log.Errorf("Unknown response type %q in rule %q", resp.Type, rule.Name)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var stickerPack = flag.String("sticker-pack", "Meow-AI", "Sticker pack name shown on stickers made by the bot")
+var stickerAuthor = flag.String("sticker-author", "@ozip.cf", "Sticker pack author shown on stickers made by the bot")
+var stickerMaxSeconds = flag.Int("sticker-max-seconds", 10, "Maximum length of videos that can be made into stickers")
+
+// stickerSize is the width and height of WhatsApp stickers.
+const stickerSize = 512
+
+// stickerFilter scales the media to fit in the sticker and pads the rest with transparency.
+var stickerFilter = fmt.Sprintf(
+	"scale=%[1]d:%[1]d:force_original_aspect_ratio=decrease,format=rgba,pad=%[1]d:%[1]d:(ow-iw)/2:(oh-ih)/2:color=black@0",
+	stickerSize,
+)
+
+var (
+	ffmpegCheck    sync.Once
+	ffmpegWebP     bool
+	ffmpegWebPAnim bool
+)
+
+// ffmpegEncoders checks if ffmpeg is installed with the libwebp encoders needed for stickers. Animated
+// stickers need libwebp_anim, which isn't included in every ffmpeg build.
+func ffmpegEncoders() (webp, webpAnim bool) {
+	ffmpegCheck.Do(func() {
+		path, err := exec.LookPath("ffmpeg")
+		if err != nil {
+			log.Warnf("ffmpeg not found, stickers are disabled: %v", err)
+			return
+		}
+		encoders, err := exec.Command(path, "-hide_banner", "-encoders").Output()
+		if err != nil {
+			log.Warnf("Failed to list ffmpeg encoders: %v", err)
+			return
+		}
+		for _, line := range strings.Split(string(encoders), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			switch fields[1] {
+			case "libwebp":
+				ffmpegWebP = true
+			case "libwebp_anim":
+				ffmpegWebPAnim = true
+			}
+		}
+		if !ffmpegWebP {
+			log.Warnf("ffmpeg wasn't built with libwebp, stickers are disabled")
+		} else if !ffmpegWebPAnim {
+			log.Warnf("ffmpeg wasn't built with libwebp_anim, video stickers are disabled")
+		}
+	})
+	return ffmpegWebP, ffmpegWebPAnim
+}
+
+// stickerMedia is an image or video message that can be made into a sticker.
+type stickerMedia interface {
+	whatsmeow.DownloadableMessage
+	GetFileLength() uint64
+}
+
+// stickerSource returns the image or video in a message or in the message it replies to.
+func stickerSource(evt *events.Message) (stickerMedia, bool) {
+	msg := evt.Message
+	if quoted := msg.GetExtendedTextMessage().GetContextInfo().GetQuotedMessage(); quoted != nil {
+		msg = quoted
+	}
+	if img := msg.GetImageMessage(); img != nil {
+		return img, false
+	} else if video := msg.GetVideoMessage(); video != nil {
+		return video, true
+	}
+	return nil, false
+}
+
+// convertToWebP converts an image or video to a sticker-sized WebP file with ffmpeg.
+func convertToWebP(data []byte, animated bool) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "meow-sticker-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input, output := filepath.Join(dir, "input"), filepath.Join(dir, "sticker.webp")
+	err = os.WriteFile(input, data, 0600)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-y", "-loglevel", "error", "-i", input}
+	if animated {
+		args = append(args,
+			"-t", fmt.Sprint(*stickerMaxSeconds), "-vf", "fps=15,"+stickerFilter,
+			"-c:v", "libwebp_anim", "-q:v", "50", "-loop", "0", "-an",
+		)
+	} else {
+		args = append(args, "-vf", stickerFilter, "-c:v", "libwebp", "-q:v", "75", "-frames:v", "1")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	stderr, err := exec.CommandContext(ctx, "ffmpeg", append(args, "-f", "webp", output)...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr))
+	}
+	return os.ReadFile(output)
+}
+
+// stickerExif builds the EXIF data WhatsApp reads the sticker pack name and author from.
+func stickerExif(packID, name, author string) []byte {
+	metadata, _ := json.Marshal(map[string]interface{}{
+		"sticker-pack-id":        packID,
+		"sticker-pack-name":      name,
+		"sticker-pack-publisher": author,
+		"emojis":                 []string{""},
+	})
+	// Little-endian TIFF header with a single IFD entry (tag 0x5741, type UNDEFINED) pointing to the JSON.
+	exif := []byte{0x49, 0x49, 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00, 0x01, 0x00, 0x41, 0x57, 0x07, 0x00, 0, 0, 0, 0, 0x16, 0x00, 0x00, 0x00}
+	binary.LittleEndian.PutUint32(exif[14:], uint32(len(metadata)))
+	return append(exif, metadata...)
+}
+
+var errInvalidWebP = errors.New("invalid WebP file")
+
+// webpCanvasSize reads the image size from a simple (non-extended) WebP bitstream chunk.
+func webpCanvasSize(fourCC string, payload []byte) (width, height int, alpha bool, err error) {
+	switch fourCC {
+	case "VP8 ":
+		if len(payload) < 10 || !bytes.Equal(payload[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, false, errInvalidWebP
+		}
+		width = int(binary.LittleEndian.Uint16(payload[6:]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(payload[8:]) & 0x3fff)
+		return width, height, false, nil
+	case "VP8L":
+		if len(payload) < 5 || payload[0] != 0x2f {
+			return 0, 0, false, errInvalidWebP
+		}
+		bits := binary.LittleEndian.Uint32(payload[1:])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, bits>>28&1 == 1, nil
+	}
+	return 0, 0, false, errInvalidWebP
+}
+
+// addWebPExif adds an EXIF chunk to a WebP file, converting it to the extended format if necessary.
+func addWebPExif(data, exif []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errInvalidWebP
+	}
+	type chunk struct {
+		fourCC  string
+		payload []byte
+	}
+	var chunks []chunk
+	for rest := data[12:]; len(rest) >= 8; {
+		size := int(binary.LittleEndian.Uint32(rest[4:]))
+		if 8+size > len(rest) {
+			return nil, errInvalidWebP
+		}
+		if fourCC := string(rest[:4]); fourCC != "EXIF" {
+			chunks = append(chunks, chunk{fourCC, rest[8 : 8+size]})
+		}
+		rest = rest[8+size+size%2:]
+	}
+	if len(chunks) == 0 {
+		return nil, errInvalidWebP
+	}
+	if chunks[0].fourCC == "VP8X" {
+		if len(chunks[0].payload) < 10 {
+			return nil, errInvalidWebP
+		}
+		vp8x := append([]byte{}, chunks[0].payload...)
+		vp8x[0] |= 0x08
+		chunks[0].payload = vp8x
+	} else {
+		width, height, alpha, err := webpCanvasSize(chunks[0].fourCC, chunks[0].payload)
+		if err != nil {
+			return nil, err
+		}
+		vp8x := make([]byte, 10)
+		vp8x[0] = 0x08
+		if alpha {
+			vp8x[0] |= 0x10
+		}
+		putUint24(vp8x[4:], width-1)
+		putUint24(vp8x[7:], height-1)
+		chunks = append([]chunk{{"VP8X", vp8x}}, chunks...)
+	}
+	chunks = append(chunks, chunk{"EXIF", exif})
+
+	var out bytes.Buffer
+	out.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	for _, c := range chunks {
+		out.WriteString(c.fourCC)
+		_ = binary.Write(&out, binary.LittleEndian, uint32(len(c.payload)))
+		out.Write(c.payload)
+		if len(c.payload)%2 == 1 {
+			out.WriteByte(0)
+		}
+	}
+	result := out.Bytes()
+	binary.LittleEndian.PutUint32(result[4:], uint32(len(result)-8))
+	return result, nil
+}
+
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// makeSticker handles the sticker command.
+func makeSticker(ctx *CommandContext) {
+	evt := ctx.Event
+	media, animated := stickerSource(evt)
+	if media == nil {
+		ctx.Reply("Kirim gambar atau video pendek dengan keterangan %s, atau balas gambarnya dengan perintah itu.", ctx.Command.Usage())
+		return
+	}
+	if webp, webpAnim := ffmpegEncoders(); !webp {
+		ctx.Reply("Maaf, fitur stiker belum tersedia di bot ini 🙏.")
+		return
+	} else if animated && !webpAnim {
+		ctx.Reply("Maaf, bot ini belum bisa membuat stiker dari video 🙏. Coba kirim gambar saja.")
+		return
+	}
+	if video, ok := media.(*waProto.VideoMessage); ok && int(video.GetSeconds()) > *stickerMaxSeconds {
+		ctx.Reply("Videonya terlalu panjang, maksimal %d detik 🙏.", *stickerMaxSeconds)
+		return
+	} else if media.GetFileLength() > uint64(*maxImageSize)*1024*1024 {
+		ctx.Reply("Maaf, filenya terlalu besar (maksimal %d MB) 🙏.", *maxImageSize)
+		return
+	}
+	reactTo(evt, "⏳")
+	defer reactTo(evt, "")
+	data, err := cli.Download(media)
+	if err != nil {
+		log.Errorf("Failed to download media for sticker %s: %v", evt.Info.ID, err)
+		ctx.Reply("Maaf, medianya gagal diunduh 🙏. Coba kirim lagi.")
+		return
+	}
+	webp, err := convertToWebP(data, animated)
+	if err == nil {
+		webp, err = addWebPExif(webp, stickerExif(randomToken(), *stickerPack, *stickerAuthor))
+	}
+	if err != nil {
+		log.Errorf("Failed to make sticker from %s: %v", evt.Info.ID, err)
+		ctx.Reply("Maaf, stikernya gagal dibuat 🙏.")
+		return
+	}
+	uploaded, err := cli.Upload(context.Background(), webp, whatsmeow.MediaImage)
+	if err != nil {
+		log.Errorf("Failed to upload sticker for %s: %v", evt.Info.ID, err)
+		ctx.Reply("Maaf, stikernya gagal dikirim 🙏.")
+		return
+	}
+	sendMessage(evt.Info.Chat, &waProto.Message{StickerMessage: &waProto.StickerMessage{
+		URL:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String("image/webp"),
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(webp))),
+		Width:         proto.Uint32(stickerSize),
+		Height:        proto.Uint32(stickerSize),
+		IsAnimated:    proto.Bool(animated),
+		ContextInfo:   quoteContext(evt),
+	}})
+}
+
+func init() {
+	registerCommand(&Command{
+		Name:     "sticker",
+		Aliases:  []string{"stiker"},
+		Help:     "Ubah gambar atau video pendek menjadi stiker. Kirim sebagai keterangan gambar atau balas gambarnya.",
+		Cooldown: 10 * time.Second,
+		Handler:  makeSticker,
+	})
+}
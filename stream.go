@@ -0,0 +1,279 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var streamMode = flag.String("stream", "", "How AI replies are sent while they're generated (edit, chunks or off), defaults to $STREAM or edit")
+var streamInterval = flag.Duration("stream-interval", 1500*time.Millisecond, "Minimum time between messages or edits of a streamed AI reply")
+var maxMessageLength = flag.Int("max-message-length", 4000, "Maximum length of a single message, longer AI replies are split at paragraph boundaries")
+
+// minMessageLength is the lowest allowed -max-message-length, which leaves room for reopening code blocks
+// in each part of a split message.
+const minMessageLength = 100
+
+// Ways of sending streamed AI replies.
+const (
+	// StreamEdit sends the reply as soon as some text is available and edits it as it grows.
+	StreamEdit = "edit"
+	// StreamChunks sends each finished paragraph as a separate message.
+	StreamChunks = "chunks"
+	// StreamOff sends the reply when it's complete.
+	StreamOff = "off"
+)
+
+// streamedMessage is a message of a reply that has already been sent.
+type streamedMessage struct {
+	ID   types.MessageID
+	Text string
+}
+
+// ReplyStream sends an AI reply to a message while it's being generated. Long replies are split into
+// several messages. It isn't safe for concurrent use, which matches how CompletionRequest.Partial is called.
+type ReplyStream struct {
+	Event *events.Message
+	Mode  string
+	// Quote makes the first message quote Event.
+	Quote bool
+
+	text string
+	sent []streamedMessage
+	// streamed is the start of the text that has already been sent in chunk mode.
+	streamed  string
+	lastFlush time.Time
+}
+
+// newReplyStream creates a stream for replying to the message using the configured stream mode.
+func newReplyStream(evt *events.Message, quote bool) *ReplyStream {
+	mode := envOr(*streamMode, "STREAM", StreamEdit)
+	if mode != StreamChunks && mode != StreamOff {
+		mode = StreamEdit
+	}
+	return &ReplyStream{Event: evt, Mode: mode, Quote: quote, lastFlush: time.Now()}
+}
+
+// sendReply sends a complete AI reply quoting the message, split into several messages if it's too long.
+// It returns the IDs of the messages that were sent.
+func sendReply(evt *events.Message, text string) ([]types.MessageID, error) {
+	stream := &ReplyStream{Event: evt, Mode: StreamOff, Quote: true}
+	return stream.Finish(text)
+}
+
+// Partial returns the callback for CompletionRequest.Partial, or nil if the reply shouldn't be streamed.
+func (rs *ReplyStream) Partial() func(text string) {
+	if rs.Mode == StreamOff {
+		return nil
+	}
+	return rs.Update
+}
+
+// Update sends the text generated so far, unless something was sent less than -stream-interval ago.
+func (rs *ReplyStream) Update(text string) {
+	rs.text = text
+	if time.Since(rs.lastFlush) < *streamInterval {
+		return
+	}
+	switch rs.Mode {
+	case StreamEdit:
+		rs.flush(splitMessage(strings.TrimSpace(text), *maxMessageLength))
+	case StreamChunks:
+		// Only finished paragraphs are sent, the last one may still grow.
+		if end := strings.LastIndex(text, "\n\n"); end > len(rs.streamed) {
+			rs.sendChunks(text[len(rs.streamed):end])
+			rs.streamed = text[:end]
+		}
+	}
+	rs.lastFlush = time.Now()
+}
+
+// Finish sends the complete reply and returns the IDs of the messages it was sent in.
+func (rs *ReplyStream) Finish(text string) ([]types.MessageID, error) {
+	var err error
+	switch rs.Mode {
+	case StreamEdit:
+		err = rs.flush(splitMessage(text, *maxMessageLength))
+	default:
+		err = rs.sendChunks(rs.unsent(text))
+		rs.streamed = text
+	}
+	ids := make([]types.MessageID, len(rs.sent))
+	for i, msg := range rs.sent {
+		ids[i] = msg.ID
+	}
+	return ids, err
+}
+
+// unsent returns the end of the text that hasn't been sent in chunk mode yet. The final text may not
+// start with the streamed text, e.g. because it's trimmed or has been cut off, so only the part where
+// they match is skipped.
+func (rs *ReplyStream) unsent(text string) string {
+	sent, text := strings.TrimSpace(rs.streamed), strings.TrimSpace(text)
+	n := 0
+	for n < len(sent) && n < len(text) && sent[n] == text[n] {
+		n++
+	}
+	for n > 0 && n < len(text) && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[n:]
+}
+
+// Abort handles an error while generating the reply. If part of the reply has already been sent, it's
+// marked as unfinished and nil is returned, so that the error isn't retried or answered with a fallback
+// message below the partial reply. Otherwise the error is returned as is.
+func (rs *ReplyStream) Abort(cause error) error {
+	if len(rs.sent) == 0 {
+		return cause
+	}
+	log.Errorf("Failed to finish AI reply to %s: %v", rs.Event.Info.ID, cause)
+	if rs.Mode == StreamEdit {
+		_ = rs.flush(splitMessage(strings.TrimSpace(rs.text)+"…", *maxMessageLength))
+	} else {
+		_ = rs.sendChunks(rs.unsent(rs.text) + "…")
+	}
+	return nil
+}
+
+// flush sends or edits messages so that they contain the given parts. Messages that were sent for
+// parts that no longer exist are deleted.
+func (rs *ReplyStream) flush(parts []string) error {
+	var lastErr error
+	for i, part := range parts {
+		if i < len(rs.sent) {
+			if rs.sent[i].Text == part {
+				continue
+			}
+			_, err := sendMessage(rs.Event.Info.Chat, cli.BuildEdit(rs.Event.Info.Chat, rs.sent[i].ID, rs.buildMessage(i, part)))
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			rs.sent[i].Text = part
+		} else if err := rs.send(part); err != nil {
+			return err
+		}
+	}
+	chat := rs.Event.Info.Chat
+	for len(rs.sent) > len(parts) {
+		_, err := sendMessage(chat, cli.BuildRevoke(chat, types.EmptyJID, rs.sent[len(rs.sent)-1].ID))
+		if err != nil {
+			return err
+		}
+		rs.sent = rs.sent[:len(rs.sent)-1]
+	}
+	return lastErr
+}
+
+// sendChunks sends the text as new messages.
+func (rs *ReplyStream) sendChunks(text string) error {
+	for _, part := range splitMessage(strings.TrimSpace(text), *maxMessageLength) {
+		if err := rs.send(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rs *ReplyStream) send(part string) error {
+	resp, err := sendMessage(rs.Event.Info.Chat, rs.buildMessage(len(rs.sent), part))
+	if err != nil {
+		return err
+	}
+	rs.sent = append(rs.sent, streamedMessage{ID: resp.ID, Text: part})
+	return nil
+}
+
+// buildMessage builds the i-th message of the reply. Only the first message quotes the original message.
+func (rs *ReplyStream) buildMessage(i int, text string) *waProto.Message {
+	text = formatWhatsApp(text)
+	if i > 0 || !rs.Quote {
+		return &waProto.Message{Conversation: proto.String(text)}
+	}
+	return &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{
+		Text:        proto.String(text),
+		ContextInfo: quoteContext(rs.Event),
+	}}
+}
+
+// splitMessage splits text into parts of at most limit bytes, preferably at paragraph boundaries,
+// then at line breaks, then at spaces. Code blocks that are split are closed at the end of the part
+// and reopened in the next one, so that each part is formatted correctly on its own.
+func splitMessage(text string, limit int) []string {
+	var parts []string
+	if limit < 1 {
+		limit = 1
+	}
+	for len(text) > limit {
+		part, rest := cutMessage(text, limit)
+		opening, fence := openFence(part)
+		if fence != "" && limit-len("\n")-len(fence) <= 2*(len(opening)+len("\n")) {
+			// There's no room for closing and reopening the block, which could keep the rest from
+			// getting shorter, so the block is split as is.
+			opening, fence = "", ""
+		} else if fence != "" && len(part)+len("\n")+len(fence) > limit {
+			part, rest = cutMessage(text, limit-len("\n")-len(fence))
+			opening, fence = openFence(part)
+		}
+		if fence != "" {
+			part += "\n" + fence
+			if firstLine, after, _ := strings.Cut(rest, "\n"); strings.HasPrefix(strings.TrimSpace(firstLine), fence) {
+				// The block ended right after the cut, so there's nothing to reopen.
+				rest = strings.TrimSpace(after)
+			} else {
+				rest = opening + "\n" + rest
+			}
+		}
+		parts = append(parts, part)
+		text = rest
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+// cutMessage cuts the first part of at most limit bytes off the text. If the limit is shorter than the
+// first character, the part is that character.
+func cutMessage(text string, limit int) (part, rest string) {
+	cut := -1
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		if idx := strings.LastIndex(text[:limit], sep); idx > limit/2 {
+			cut = idx
+			break
+		}
+	}
+	if cut < 0 {
+		cut = limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+	}
+	return strings.TrimSpace(text[:cut]), strings.TrimSpace(text[cut:])
+}
+
+// openFence returns the opening line and the fence of a fenced code block that isn't closed by the end
+// of the text, or empty strings if there isn't one.
+func openFence(text string) (opening, fence string) {
+	for _, line := range strings.Split(text, "\n") {
+		if fence == "" {
+			if match := mdFence.FindStringSubmatch(line); match != nil {
+				opening, fence = strings.TrimSpace(line), match[1]
+			}
+		} else if strings.HasPrefix(strings.TrimSpace(line), fence) {
+			opening, fence = "", ""
+		}
+	}
+	return opening, fence
+}
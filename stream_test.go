@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMessage(t *testing.T) {
+	code := "```go\n" + strings.Repeat("fmt.Println(\"hello\")\n", 12) + "```"
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"Short", "halo", 10, []string{"halo"}},
+		{"Paragraphs", "satu dua\n\ntiga empat", 12, []string{"satu dua", "tiga empat"}},
+		{"Words", "satu dua tiga empat", 10, []string{"satu dua", "tiga empat"}},
+		{"Runes", "ééééé", 5, []string{"éé", "éé", "é"}},
+		{"CodeBlock", "Kode:\n\n" + code, 120, []string{
+			"Kode:\n\n```go\n" + strings.Repeat("fmt.Println(\"hello\")\n", 4) + "```",
+			"```go\n" + strings.Repeat("fmt.Println(\"hello\")\n", 5) + "```",
+			"```go\n" + strings.Repeat("fmt.Println(\"hello\")\n", 3) + "```",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := splitMessage(test.text, test.limit)
+			if strings.Join(got, "\x00") != strings.Join(test.want, "\x00") {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			for _, part := range got {
+				if len(part) > test.limit {
+					t.Errorf("part %q is longer than %d bytes", part, test.limit)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitMessageSmallLimits(t *testing.T) {
+	texts := []string{
+		"halo dunia",
+		"ééé ééé",
+		"Kode:\n```python\nprint('halo')\nprint('dunia')\n```\nselesai",
+		"```\n" + strings.Repeat("x", 50) + "\n```",
+	}
+	for _, text := range texts {
+		for limit := -1; limit <= 40; limit++ {
+			parts := splitMessage(text, limit)
+			if len(parts) > len(text) {
+				t.Fatalf("splitMessage(%q, %d) returned %d parts", text, limit, len(parts))
+			}
+			for _, part := range parts {
+				// A single character may be longer than a tiny limit.
+				if len(part) > limit && utf8.RuneCountInString(part) > 1 {
+					t.Errorf("splitMessage(%q, %d) returned part %q over the limit", text, limit, part)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var typingEnabled = flag.Bool("typing", true, "Show a typing indicator while the AI is generating a reply, unless disabled in the chat")
+var readReceipts = flag.Bool("read-receipts", true, "Mark messages as read when the AI starts answering them, unless disabled in the chat")
+
+// typingRefresh is how often the typing indicator is resent. WhatsApp hides it after about 25 seconds.
+const typingRefresh = 10 * time.Second
+
+// feedback stores the per-chat typing indicator and read receipt settings.
+var feedback *FeedbackSettings
+
+// FeedbackSettings keeps per-chat overrides of the -typing and -read-receipts flags.
+type FeedbackSettings struct {
+	db *sql.DB
+}
+
+const createFeedbackTableQuery = `
+CREATE TABLE IF NOT EXISTS meow_feedback (
+	chat          TEXT PRIMARY KEY,
+	typing        BOOLEAN,
+	read_receipts BOOLEAN
+)`
+
+// NewFeedbackSettings wraps an existing database connection and creates the feedback settings table if necessary.
+func NewFeedbackSettings(db *sql.DB) (*FeedbackSettings, error) {
+	_, err := db.Exec(createFeedbackTableQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create feedback table: %w", err)
+	}
+	return &FeedbackSettings{db: db}, nil
+}
+
+// Get returns whether typing indicators and read receipts are enabled in the chat.
+func (fs *FeedbackSettings) Get(chat types.JID) (typing, read bool, err error) {
+	var typingVal, readVal sql.NullBool
+	err = fs.db.QueryRow(`SELECT typing, read_receipts FROM meow_feedback WHERE chat=$1`, chat.String()).Scan(&typingVal, &readVal)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	typing, read = *typingEnabled, *readReceipts
+	if typingVal.Valid {
+		typing = typingVal.Bool
+	}
+	if readVal.Valid {
+		read = readVal.Bool
+	}
+	return
+}
+
+// SetTyping enables or disables typing indicators in the chat.
+func (fs *FeedbackSettings) SetTyping(chat types.JID, enabled bool) error {
+	_, err := fs.db.Exec(`
+		INSERT INTO meow_feedback (chat, typing) VALUES ($1, $2)
+		ON CONFLICT (chat) DO UPDATE SET typing=excluded.typing
+	`, chat.String(), enabled)
+	return err
+}
+
+// SetReadReceipts enables or disables read receipts in the chat.
+func (fs *FeedbackSettings) SetReadReceipts(chat types.JID, enabled bool) error {
+	_, err := fs.db.Exec(`
+		INSERT INTO meow_feedback (chat, read_receipts) VALUES ($1, $2)
+		ON CONFLICT (chat) DO UPDATE SET read_receipts=excluded.read_receipts
+	`, chat.String(), enabled)
+	return err
+}
+
+// startTyping marks the message as read and shows a typing indicator in its chat until the returned
+// function is called, depending on the chat's settings. Use types.ChatPresenceMediaAudio to show
+// "recording audio" instead of "typing".
+func startTyping(evt *events.Message, media types.ChatPresenceMedia) (stop func()) {
+	typing, read, err := feedback.Get(evt.Info.Chat)
+	if err != nil {
+		log.Errorf("Failed to get feedback settings of %s: %v", evt.Info.Chat, err)
+	}
+	if read {
+		err = cli.MarkRead([]types.MessageID{evt.Info.ID}, time.Now(), evt.Info.Chat, evt.Info.Sender)
+		if err != nil {
+			log.Warnf("Failed to mark %s as read: %v", evt.Info.ID, err)
+		}
+	}
+	if !typing {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(typingRefresh)
+		defer ticker.Stop()
+		for {
+			err := cli.SendChatPresence(evt.Info.Chat, types.ChatPresenceComposing, media)
+			if err != nil {
+				log.Warnf("Failed to send typing indicator to %s: %v", evt.Info.Chat, err)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				err = cli.SendChatPresence(evt.Info.Chat, types.ChatPresencePaused, media)
+				if err != nil {
+					log.Warnf("Failed to stop typing indicator in %s: %v", evt.Info.Chat, err)
+				}
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func init() {
+	registerCommand(&Command{
+		Name: "feedback",
+		Args: []CommandArg{{Name: "typing/read", Type: ArgString}, {Name: "on/off", Type: ArgString}},
+		Help: "Atur indikator mengetik (typing) atau tanda dibaca (read) saat bot menjawab di chat ini.",
+		Handler: func(ctx *CommandContext) {
+			evt := ctx.Event
+			setting, state := strings.ToLower(ctx.String("typing/read")), strings.ToLower(ctx.String("on/off"))
+			if evt.Info.IsGroup && ctx.Level() < LevelAdmin {
+				ctx.Reply("Maaf, di grup perintah ini hanya untuk admin 🙏.")
+				return
+			} else if state != "on" && state != "off" {
+				ctx.Reply("Cara pakai: %s", ctx.Command.Usage())
+				return
+			}
+			var err error
+			switch setting {
+			case "typing":
+				err = feedback.SetTyping(evt.Info.Chat, state == "on")
+			case "read":
+				err = feedback.SetReadReceipts(evt.Info.Chat, state == "on")
+			default:
+				ctx.Reply("Cara pakai: %s", ctx.Command.Usage())
+				return
+			}
+			if err != nil {
+				log.Errorf("Failed to update feedback settings of %s: %v", evt.Info.Chat, err)
+				ctx.Reply("Maaf, pengaturan gagal disimpan 🙏.")
+			} else if state == "on" {
+				ctx.Reply("Pengaturan *%s* diaktifkan di chat ini.", setting)
+			} else {
+				ctx.Reply("Pengaturan *%s* dinonaktifkan di chat ini.", setting)
+			}
+		},
+	})
+}
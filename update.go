@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"go.mau.fi/whatsmeow/socket"
+	"go.mau.fi/whatsmeow/store"
+)
+
+// checkUpdateURL is the endpoint WhatsApp web uses to check whether its version is still supported.
+const checkUpdateURL = "https://web.whatsapp.com/check-update"
+
+// UpdateInfo is the response of the WhatsApp web update check.
+type UpdateInfo struct {
+	IsBroken       bool
+	IsBelowSoft    bool
+	IsBelowHard    bool
+	CurrentVersion string
+
+	ParsedVersion store.WAVersionContainer `json:"-"`
+}
+
+// checkUpdate asks the WhatsApp servers for the latest web client version. whatsmeow no longer ships this
+// check, so it's done here the same way the checkupdate console command used to do it.
+func checkUpdate() (info UpdateInfo, err error) {
+	q := url.Values{}
+	q.Set("version", store.GetWAVersion().String())
+	q.Set("platform", "web")
+	req, err := http.NewRequest(http.MethodGet, checkUpdateURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return info, fmt.Errorf("failed to prepare request: %w", err)
+	}
+	req.Header.Set("Origin", socket.Origin)
+	req.Header.Set("Referer", socket.Origin+"/")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return info, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return info, fmt.Errorf("unexpected response with status %d: %s", resp.StatusCode, body)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return info, fmt.Errorf("failed to decode response: %w", err)
+	}
+	info.ParsedVersion, err = store.ParseVersion(info.CurrentVersion)
+	if err != nil {
+		return info, fmt.Errorf("failed to parse version %q: %w", info.CurrentVersion, err)
+	}
+	return info, nil
+}
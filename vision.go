@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var visionModel = flag.String("vision-model", "", "Model used for questions about images, defaults to $VISION_MODEL or the normal AI model")
+var maxImageSize = flag.Int("max-image-size", 5, "Maximum size of images sent to the AI in megabytes")
+var mediaCacheTTL = flag.Duration("media-cache-ttl", 24*time.Hour, "How long incoming images are kept for questions about them")
+var mediaCacheSize = flag.Int("media-cache-size", 200, "Maximum total size of cached images in megabytes, the oldest ones are removed first (0 for unlimited)")
+
+// Errors returned by MediaCache.Fetch for images that can't be sent to the AI.
+var (
+	ErrImageTooLarge    = errors.New("image is too large")
+	ErrUnsupportedImage = errors.New("unsupported image type")
+)
+
+// supportedImageTypes are the image formats accepted by vision backends.
+var supportedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// images caches incoming images so they can be asked about later.
+var images *MediaCache
+
+// MediaCache stores downloaded images in the database for a limited time and up to a total size.
+type MediaCache struct {
+	db *sql.DB
+
+	lock     sync.Mutex
+	inFlight map[string]chan struct{}
+}
+
+const createMediaTableQuery = `
+CREATE TABLE IF NOT EXISTS meow_media (
+	chat       TEXT   NOT NULL,
+	message_id TEXT   NOT NULL,
+	mimetype   TEXT   NOT NULL,
+	data       BLOB   NOT NULL,
+	timestamp  BIGINT NOT NULL,
+	PRIMARY KEY (chat, message_id)
+)`
+
+// NewMediaCache wraps an existing database connection and creates the media table if necessary.
+func NewMediaCache(db *sql.DB) (*MediaCache, error) {
+	_, err := db.Exec(createMediaTableQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create media table: %w", err)
+	}
+	return &MediaCache{db: db, inFlight: make(map[string]chan struct{})}, nil
+}
+
+func (mc *MediaCache) get(chat types.JID, id types.MessageID) (*ChatImage, error) {
+	var img ChatImage
+	err := mc.db.QueryRow(
+		`SELECT mimetype, data FROM meow_media WHERE chat=$1 AND message_id=$2`, chat.String(), id,
+	).Scan(&img.Mimetype, &img.Data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+func (mc *MediaCache) put(chat types.JID, id types.MessageID, img *ChatImage) error {
+	_, err := mc.db.Exec(`
+		INSERT INTO meow_media (chat, message_id, mimetype, data, timestamp) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (chat, message_id) DO UPDATE SET mimetype=excluded.mimetype, data=excluded.data, timestamp=excluded.timestamp
+	`, chat.String(), id, img.Mimetype, img.Data, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return mc.prune()
+}
+
+// prune removes images older than -media-cache-ttl, then the oldest images until the rest fit in -media-cache-size.
+func (mc *MediaCache) prune() error {
+	_, err := mc.db.Exec(`DELETE FROM meow_media WHERE timestamp < $1`, time.Now().Add(-*mediaCacheTTL).Unix())
+	if err != nil || *mediaCacheSize <= 0 {
+		return err
+	}
+	rows, err := mc.db.Query(`SELECT chat, message_id, LENGTH(data) FROM meow_media ORDER BY timestamp DESC`)
+	if err != nil {
+		return err
+	}
+	type mediaKey struct{ chat, id string }
+	var total int64
+	var evict []mediaKey
+	for rows.Next() {
+		var key mediaKey
+		var size int64
+		if err = rows.Scan(&key.chat, &key.id, &size); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		total += size
+		if total > int64(*mediaCacheSize)*1024*1024 {
+			evict = append(evict, key)
+		}
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, key := range evict {
+		_, err = mc.db.Exec(`DELETE FROM meow_media WHERE chat=$1 AND message_id=$2`, key.chat, key.id)
+		if err != nil {
+			return err
+		}
+	}
+	if len(evict) > 0 {
+		log.Debugf("Removed %d images from the media cache to stay under %d MB", len(evict), *mediaCacheSize)
+	}
+	return nil
+}
+
+// wait makes sure the same image isn't downloaded by multiple goroutines at once. The returned
+// function must be called when the image has been stored.
+func (mc *MediaCache) wait(key string) func() {
+	for {
+		mc.lock.Lock()
+		ch, ok := mc.inFlight[key]
+		if !ok {
+			ch = make(chan struct{})
+			mc.inFlight[key] = ch
+			mc.lock.Unlock()
+			return func() {
+				mc.lock.Lock()
+				delete(mc.inFlight, key)
+				mc.lock.Unlock()
+				close(ch)
+			}
+		}
+		mc.lock.Unlock()
+		<-ch
+	}
+}
+
+// Fetch returns the image of a message from the cache, or downloads, validates and caches it.
+func (mc *MediaCache) Fetch(chat types.JID, id types.MessageID, msg *waProto.ImageMessage) (*ChatImage, error) {
+	done := mc.wait(chat.String() + "/" + id)
+	defer done()
+	img, err := mc.get(chat, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached image: %w", err)
+	} else if img != nil {
+		return img, nil
+	}
+	maxSize := uint64(*maxImageSize) * 1024 * 1024
+	mimetype, _, _ := mime.ParseMediaType(msg.GetMimetype())
+	if !supportedImageTypes[mimetype] {
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedImage, msg.GetMimetype())
+	} else if msg.GetFileLength() > maxSize {
+		return nil, ErrImageTooLarge
+	}
+	data, err := cli.Download(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	} else if uint64(len(data)) > maxSize {
+		return nil, ErrImageTooLarge
+	} else if detected := http.DetectContentType(data); !supportedImageTypes[detected] {
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedImage, detected)
+	} else {
+		mimetype = detected
+	}
+	img = &ChatImage{Mimetype: mimetype, Data: data}
+	err = mc.put(chat, id, img)
+	if err != nil {
+		log.Warnf("Failed to cache image %s: %v", id, err)
+	}
+	return img, nil
+}
+
+// messageImage returns the image in a message or in the message it replies to, along with the ID of
+// the message containing the image.
+func messageImage(evt *events.Message) (*waProto.ImageMessage, types.MessageID) {
+	if img := evt.Message.GetImageMessage(); img != nil {
+		return img, evt.Info.ID
+	}
+	ctxInfo := evt.Message.GetExtendedTextMessage().GetContextInfo()
+	if img := ctxInfo.GetQuotedMessage().GetImageMessage(); img != nil {
+		return img, ctxInfo.GetStanzaId()
+	}
+	return nil, ""
+}
+
+// generateImageReply asks the vision backend about an image and returns the text to send.
+func generateImageReply(ctx context.Context, chat types.JID, question string, img *ChatImage, partial func(string)) (string, error) {
+	req := buildChatRequest(chat, nil, question)
+	req.Partial = partial
+	req.Messages[len(req.Messages)-1].Images = []ChatImage{*img}
+	req.Model = envOr(*visionModel, "VISION_MODEL", "")
+	completion, err := ai.Complete(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return completionText(completion)
+}
+
+// answerImage answers a question about the image in a message or the message it replies to.
+// AI errors are returned, other errors are replied to directly.
+func answerImage(ctx context.Context, evt *events.Message, question string) error {
+	msg, id := messageImage(evt)
+	img, err := images.Fetch(evt.Info.Chat, id, msg)
+	if errors.Is(err, ErrImageTooLarge) {
+		replyQuote(evt, fmt.Sprintf("Maaf, gambarnya terlalu besar (maksimal %d MB) 🙏.", *maxImageSize))
+		return nil
+	} else if errors.Is(err, ErrUnsupportedImage) {
+		replyQuote(evt, "Maaf, format gambar ini tidak didukung. Kirim gambar JPEG, PNG atau WebP 🙏.")
+		return nil
+	} else if err != nil {
+		log.Errorf("Failed to get image for %s: %v", evt.Info.ID, err)
+		replyQuote(evt, "Maaf, gambarnya gagal diproses 🙏. Coba kirim lagi.")
+		return nil
+	}
+	defer startTyping(evt, types.ChatPresenceMediaText)()
+	stream := newReplyStream(evt, true)
+	answer, err := generateImageReply(aiContext(ctx, evt), evt.Info.Chat, question, img, stream.Partial())
+	if err != nil {
+		return stream.Abort(err)
+	}
+	ids, err := stream.Finish(answer)
+	if err != nil {
+		log.Errorf("Failed to send AI reply to %s: %v", evt.Info.ID, err)
+	}
+	if len(ids) > 0 {
+		history.Remember(evt.Info.Chat, evt.Info.ID, "[gambar] "+question, ids, answer)
+	}
+	return nil
+}
+
+// handleImageMessage answers images sent directly to the bot. Images without a caption are only
+// cached, so that the user can reply to them with a question.
+func handleImageMessage(ctx context.Context, evt *events.Message) error {
+	if err := checkAccess(evt); err != nil {
+		return err
+	}
+	caption := evt.Message.GetImageMessage().GetCaption()
+	if caption == "" {
+		cacheImage(evt)
+		replyQuote(evt, "Gambarnya sudah kuterima 📷. Balas gambar ini dengan pertanyaanmu, misalnya \"apa ini?\".")
+		return nil
+	}
+	if verdict, found := moderator.Check(evt.Info.Chat, caption); found {
+		applyModeration(evt, verdict)
+	} else if moderator.Muted(evt.Info.Sender) {
+		log.Debugf("Not answering %s from muted sender %s", evt.Info.ID, evt.Info.Sender)
+	} else {
+		return answerImage(ctx, evt, caption)
+	}
+	return nil
+}
+
+// cacheImage stores an incoming image that isn't being answered right away.
+func cacheImage(evt *events.Message) {
+	_, err := images.Fetch(evt.Info.Chat, evt.Info.ID, evt.Message.GetImageMessage())
+	if err != nil {
+		log.Debugf("Not caching image %s: %v", evt.Info.ID, err)
+	} else {
+		log.Debugf("Cached image in message %s", evt.Info.ID)
+	}
+}
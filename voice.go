@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var sttBackend = flag.String("stt-backend", "", "Speech-to-text backend for voice notes (whisper or fake), defaults to $STT_BACKEND; voice notes aren't answered without one")
+var sttURL = flag.String("stt-url", "", "Base URL of the whisper-compatible transcription API, defaults to $STT_URL")
+var sttModel = flag.String("stt-model", "", "Speech-to-text model, defaults to $STT_MODEL")
+var ttsBackend = flag.String("tts-backend", "", "Text-to-speech backend for answering voice notes with voice notes (openai), defaults to $TTS_BACKEND; answers are sent as text without one")
+var ttsURL = flag.String("tts-url", "", "Base URL of the OpenAI-compatible speech API, defaults to $TTS_URL")
+var ttsVoice = flag.String("tts-voice", "", "Voice used for spoken replies, defaults to $TTS_VOICE")
+var maxVoiceSeconds = flag.Int("max-voice-seconds", 300, "Maximum length of voice notes that are transcribed in seconds")
+var maxVoiceSize = flag.Int("max-voice-size", 5, "Maximum size of voice notes that are transcribed in megabytes")
+
+// voiceTimeout limits the time of a single transcription or speech synthesis request.
+const voiceTimeout = 2 * time.Minute
+
+// transcriptTTL is how long transcripts are kept, so that a voice note that's handled again isn't
+// downloaded and transcribed again.
+const transcriptTTL = time.Hour
+
+// ErrVoiceTooLong is returned by transcribeVoiceNote for voice notes over -max-voice-seconds or -max-voice-size.
+var ErrVoiceTooLong = errors.New("voice note is too long")
+
+// stt transcribes voice notes, nil if voice notes aren't supported.
+var stt Transcriber
+
+// voiceLimiter limits how many voice notes each user can have transcribed per minute.
+var voiceLimiter *RateLimiter
+
+// tts speaks replies to voice notes, nil if replies are sent as text.
+var tts Synthesizer
+
+// Transcriber converts speech to text. Implementations must be safe for concurrent use.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio []byte, mimetype string) (string, error)
+}
+
+// Synthesizer converts text to speech. Implementations must return Ogg Opus audio, which is the
+// only format WhatsApp plays as a voice note.
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string) ([]byte, error)
+}
+
+// newVoiceBackends creates the Transcriber and Synthesizer selected with flags or environment variables.
+// Either of them is nil if the corresponding backend isn't configured.
+func newVoiceBackends() (Transcriber, Synthesizer, error) {
+	var transcriber Transcriber
+	var synthesizer Synthesizer
+	switch backend := envOr(*sttBackend, "STT_BACKEND", ""); backend {
+	case "":
+	case "whisper":
+		transcriber = &RetryingTranscriber{
+			Transcriber: &WhisperTranscriber{
+				BaseURL: envOr(*sttURL, "STT_URL", "http://localhost:9000/v1"),
+				APIKey:  os.Getenv("API_KEY"),
+				Model:   envOr(*sttModel, "STT_MODEL", "whisper-1"),
+			},
+			Attempts:  *llmRetries,
+			BaseDelay: time.Second,
+		}
+	case "fake":
+		transcriber = FakeTranscriber{}
+	default:
+		return nil, nil, fmt.Errorf("unknown speech-to-text backend %q", backend)
+	}
+	switch backend := envOr(*ttsBackend, "TTS_BACKEND", ""); backend {
+	case "":
+	case "openai":
+		synthesizer = &OpenAISynthesizer{
+			BaseURL: envOr(*ttsURL, "TTS_URL", "https://api.openai.com/v1"),
+			APIKey:  os.Getenv("API_KEY"),
+			Model:   "tts-1",
+			Voice:   envOr(*ttsVoice, "TTS_VOICE", "alloy"),
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown text-to-speech backend %q", backend)
+	}
+	return transcriber, synthesizer, nil
+}
+
+// WhisperTranscriber uses the audio transcription endpoint of OpenAI or any server implementing
+// the same API (e.g. faster-whisper-server or LocalAI).
+type WhisperTranscriber struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+	Client  *http.Client
+}
+
+func (wt *WhisperTranscriber) Transcribe(ctx context.Context, audio []byte, mimetype string) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("model", wt.Model)
+	file, err := form.CreateFormFile("file", "voice"+audioExtension(mimetype))
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare request: %w", err)
+	}
+	_, _ = file.Write(audio)
+	_ = form.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(wt.BaseURL, "/")+"/audio/transcriptions", &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if wt.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+wt.APIKey)
+	}
+	data, err := doAPIRequest(wt.Client, req)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Text string `json:"text"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return strings.TrimSpace(resp.Text), nil
+}
+
+// audioExtension returns the file extension for an audio mimetype, which transcription servers use to detect the format.
+func audioExtension(mimetype string) string {
+	mediaType, _, _ := mime.ParseMediaType(mimetype)
+	switch mediaType {
+	case "audio/ogg", "":
+		return ".ogg"
+	case "audio/mpeg":
+		return ".mp3"
+	case "audio/mp4", "audio/aac":
+		return ".m4a"
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".ogg"
+}
+
+// FakeTranscriber is a deterministic Transcriber that doesn't call any API, meant for running the bot offline.
+type FakeTranscriber struct{}
+
+func (FakeTranscriber) Transcribe(_ context.Context, audio []byte, _ string) (string, error) {
+	return fmt.Sprintf("Pesan suara (%d byte)", len(audio)), nil
+}
+
+// OpenAISynthesizer uses the speech endpoint of OpenAI or any server implementing the same API.
+type OpenAISynthesizer struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+	Voice   string
+	Client  *http.Client
+}
+
+func (synth *OpenAISynthesizer) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{
+		"model":           synth.Model,
+		"voice":           synth.Voice,
+		"input":           text,
+		"response_format": "opus",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(synth.BaseURL, "/")+"/audio/speech", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if synth.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+synth.APIKey)
+	}
+	return doAPIRequest(synth.Client, req)
+}
+
+// TranscriptCache keeps recent voice note transcripts by message ID.
+type TranscriptCache struct {
+	lock      sync.Mutex
+	entries   map[types.MessageID]cachedTranscript
+	lastPrune time.Time
+}
+
+type cachedTranscript struct {
+	Text    string
+	Expires time.Time
+}
+
+var transcripts = &TranscriptCache{entries: make(map[types.MessageID]cachedTranscript)}
+
+// Get returns the transcript of the message, if it has been transcribed recently.
+func (tc *TranscriptCache) Get(id types.MessageID) (string, bool) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	entry, ok := tc.entries[id]
+	if !ok || time.Now().After(entry.Expires) {
+		return "", false
+	}
+	return entry.Text, true
+}
+
+// Put stores the transcript of the message and drops expired ones.
+func (tc *TranscriptCache) Put(id types.MessageID, text string) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	now := time.Now()
+	tc.entries[id] = cachedTranscript{Text: text, Expires: now.Add(transcriptTTL)}
+	if now.Sub(tc.lastPrune) > time.Minute {
+		for id, entry := range tc.entries {
+			if now.After(entry.Expires) {
+				delete(tc.entries, id)
+			}
+		}
+		tc.lastPrune = now
+	}
+}
+
+// transcribeVoiceNote downloads and transcribes the audio in a message. Transcripts are cached by message ID.
+func transcribeVoiceNote(ctx context.Context, evt *events.Message) (string, error) {
+	if text, ok := transcripts.Get(evt.Info.ID); ok {
+		return text, nil
+	}
+	audio := evt.Message.GetAudioMessage()
+	maxSize := uint64(*maxVoiceSize) * 1024 * 1024
+	if audio.GetSeconds() > uint32(*maxVoiceSeconds) || audio.GetFileLength() > maxSize {
+		return "", ErrVoiceTooLong
+	}
+	data, err := cli.Download(audio)
+	if err != nil {
+		return "", fmt.Errorf("failed to download audio: %w", err)
+	} else if uint64(len(data)) > maxSize {
+		// The file length in the message is set by the sender and may be wrong.
+		return "", ErrVoiceTooLong
+	}
+	text, err := stt.Transcribe(ctx, data, audio.GetMimetype())
+	if err != nil {
+		return "", fmt.Errorf("failed to transcribe audio: %w", err)
+	}
+	transcripts.Put(evt.Info.ID, text)
+	return text, nil
+}
+
+// replyVoice speaks the text and sends it as a voice note quoting the given message. If speech synthesis
+// fails, the text is sent instead. It returns the IDs of the sent messages.
+func replyVoice(ctx context.Context, evt *events.Message, text string) ([]types.MessageID, error) {
+	ctx, cancel := context.WithTimeout(ctx, voiceTimeout)
+	defer cancel()
+	audio, err := tts.Synthesize(ctx, speechText(text))
+	if err != nil {
+		log.Errorf("Failed to synthesize voice reply to %s: %v", evt.Info.ID, err)
+		return sendReply(evt, text)
+	}
+	msg, err := buildMediaMessage(audio, whatsmeow.MediaAudio, "audio/ogg; codecs=opus", "", quoteContext(evt))
+	if err != nil {
+		log.Errorf("Failed to prepare voice reply to %s: %v", evt.Info.ID, err)
+		return sendReply(evt, text)
+	}
+	msg.AudioMessage.PTT = proto.Bool(true)
+	resp, err := sendMessage(evt.Info.Chat, msg)
+	if err != nil {
+		return nil, err
+	}
+	return []types.MessageID{resp.ID}, nil
+}
+
+// handleVoiceMessage answers a voice note by transcribing it and passing the text to the AI.
+// AI errors are returned, other errors are replied to directly.
+func handleVoiceMessage(ctx context.Context, evt *events.Message) error {
+	if err := checkAccess(evt); err != nil {
+		return err
+	} else if moderator.Muted(evt.Info.Sender) {
+		log.Debugf("Not answering %s from muted sender %s", evt.Info.ID, evt.Info.Sender)
+		return nil
+	} else if !evt.Info.IsFromMe && !isOwner(evt.Info.Sender) && !voiceLimiter.Allow(evt.Info.Sender.ToNonAD().String()) {
+		// Transcription isn't free either, so it's limited before the AI rate limit applies.
+		return ErrRateLimited
+	}
+	text, err := transcribeVoiceNote(ctx, evt)
+	if errors.Is(err, ErrVoiceTooLong) {
+		replyQuote(evt, fmt.Sprintf("Maaf, pesan suaranya terlalu panjang (maksimal %d detik) 🙏. Coba ketik pesanmu.", *maxVoiceSeconds))
+		return nil
+	} else if err != nil {
+		log.Errorf("Failed to handle voice note %s: %v", evt.Info.ID, err)
+		replyQuote(evt, "Maaf, pesan suaranya gagal diproses 🙏. Coba kirim lagi atau ketik pesanmu.")
+		return nil
+	} else if text == "" {
+		replyQuote(evt, "Maaf, pesan suaranya tidak terdengar jelas 🙏.")
+		return nil
+	}
+	log.Debugf("Transcribed voice note %s: %s", evt.Info.ID, text)
+	if verdict, found := moderator.Check(evt.Info.Chat, text); found {
+		applyModeration(evt, verdict)
+		return nil
+	}
+	media := types.ChatPresenceMediaText
+	if tts != nil {
+		media = types.ChatPresenceMediaAudio
+	}
+	defer startTyping(evt, media)()
+	answer, err := generateReply(aiContext(ctx, evt), evt.Info.Chat, quotedMessage(evt), text, nil)
+	if err != nil {
+		return err
+	}
+	var ids []types.MessageID
+	if tts != nil {
+		ids, err = replyVoice(ctx, evt, answer)
+	} else {
+		ids, err = sendReply(evt, answer)
+	}
+	if err != nil {
+		log.Errorf("Failed to send AI reply to %s: %v", evt.Info.ID, err)
+	}
+	if len(ids) > 0 {
+		history.Remember(evt.Info.Chat, evt.Info.ID, text, ids, answer)
+	}
+	return nil
+}
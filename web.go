@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var httpAddr = flag.String("http-addr", ":3000", "Address for the HTTP server with the login page")
+
+// httpMux is the router of the bot's HTTP server. Handlers are registered before startHTTPServer is called.
+var httpMux = http.NewServeMux()
+
+// startHTTPServer starts serving httpMux in the background.
+func startHTTPServer() {
+	server := &http.Server{
+		Addr:              *httpAddr,
+		Handler:           httpMux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Infof("HTTP server listening on %s", *httpAddr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("HTTP server failed: %v", err)
+		}
+	}()
+}
+
+// randomToken generates a random hex token for when no access token is configured.
+func randomToken() string {
+	data := make([]byte, 16)
+	_, err := rand.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(data)
+}
+
+// requestToken returns the access token of a request from the Authorization header, the token query
+// parameter or the given cookie.
+func requestToken(r *http.Request, cookieName string) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	} else if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	} else if cookie, err := r.Cookie(cookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// tokenMatches compares tokens in constant time.
+func tokenMatches(given, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
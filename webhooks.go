@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var webhooksFile = flag.String("webhooks", "webhooks.json", "JSON file with outbound webhook subscriptions")
+var webhookRetries = flag.Int("webhook-retries", 5, "Number of delivery attempts before a webhook event is moved to the dead-letter table")
+var webhookTimeout = flag.Duration("webhook-timeout", 10*time.Second, "Timeout for a single webhook delivery")
+
+// Types of events sent to webhooks.
+const (
+	WebhookMessage  = "message"
+	WebhookReceipt  = "receipt"
+	WebhookPollVote = "poll_vote"
+)
+
+// webhooks delivers incoming events to the configured subscriptions.
+var webhooks *Webhooks
+
+// WebhookSubscription is a single endpoint in the webhooks file.
+type WebhookSubscription struct {
+	URL string `json:"url"`
+	// Secret is used to sign payloads with HMAC-SHA256. The signature is sent in the X-Meow-Signature header.
+	Secret string `json:"secret,omitempty"`
+	// Events is the list of event types to send. All events are sent if it's empty.
+	Events []string `json:"events,omitempty"`
+	// Chats limits the subscription to the given chat JIDs. Events from all chats are sent if it's empty.
+	Chats []string `json:"chats,omitempty"`
+}
+
+func (sub *WebhookSubscription) wants(evt *WebhookEvent) bool {
+	return (len(sub.Events) == 0 || containsString(sub.Events, evt.Type)) &&
+		(len(sub.Chats) == 0 || containsString(sub.Chats, evt.Chat.String()))
+}
+
+func containsString(list []string, item string) bool {
+	for _, val := range list {
+		if val == item {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent is the normalized JSON payload posted to webhooks.
+type WebhookEvent struct {
+	Type      string    `json:"type"`
+	Timestamp int64     `json:"timestamp"`
+	Chat      types.JID `json:"chat"`
+	Sender    types.JID `json:"sender"`
+	IsFromMe  bool      `json:"is_from_me"`
+	IsGroup   bool      `json:"is_group"`
+
+	Message  *WebhookMessageData  `json:"message,omitempty"`
+	Receipt  *WebhookReceiptData  `json:"receipt,omitempty"`
+	PollVote *WebhookPollVoteData `json:"poll_vote,omitempty"`
+}
+
+type WebhookMessageData struct {
+	ID        types.MessageID `json:"id"`
+	PushName  string          `json:"push_name,omitempty"`
+	Type      string          `json:"type"`
+	MediaType string          `json:"media_type,omitempty"`
+	Text      string          `json:"text,omitempty"`
+	QuotedID  string          `json:"quoted_id,omitempty"`
+}
+
+type WebhookReceiptData struct {
+	MessageIDs []types.MessageID `json:"message_ids"`
+	Type       string            `json:"type"`
+}
+
+type WebhookPollVoteData struct {
+	PollID types.MessageID `json:"poll_id"`
+	// SelectedOptions contains the hex-encoded SHA-256 hashes of the selected option names.
+	SelectedOptions []string `json:"selected_options"`
+}
+
+// Webhooks posts events to the subscriptions loaded from the webhooks file and keeps failed
+// deliveries in a dead-letter table.
+type Webhooks struct {
+	db     *sql.DB
+	client *http.Client
+
+	lock          sync.RWMutex
+	subscriptions []*WebhookSubscription
+}
+
+const createDeadLettersTableQuery = `
+CREATE TABLE IF NOT EXISTS meow_webhook_dead_letters (
+	url       TEXT   NOT NULL,
+	event     TEXT   NOT NULL,
+	payload   TEXT   NOT NULL,
+	error     TEXT   NOT NULL,
+	timestamp BIGINT NOT NULL
+)`
+
+// NewWebhooks wraps an existing database connection and creates the dead-letter table if necessary.
+func NewWebhooks(db *sql.DB) (*Webhooks, error) {
+	if *webhookRetries < 1 {
+		return nil, fmt.Errorf("-webhook-retries must be at least 1, got %d", *webhookRetries)
+	}
+	_, err := db.Exec(createDeadLettersTableQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook dead-letter table: %w", err)
+	}
+	return &Webhooks{db: db, client: &http.Client{Timeout: *webhookTimeout}}, nil
+}
+
+// Load reads the subscriptions from the webhooks file. A missing file disables webhooks.
+func (wh *Webhooks) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var subscriptions []*WebhookSubscription
+	err = json.Unmarshal(data, &subscriptions)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, sub := range subscriptions {
+		if sub.URL == "" {
+			return fmt.Errorf("webhook subscription without url in %s", path)
+		}
+	}
+	wh.lock.Lock()
+	wh.subscriptions = subscriptions
+	wh.lock.Unlock()
+	log.Infof("Loaded %d webhook subscriptions from %s", len(subscriptions), path)
+	return nil
+}
+
+// Dispatch sends the event in the background to every subscription that wants it.
+func (wh *Webhooks) Dispatch(evt *WebhookEvent) {
+	wh.lock.RLock()
+	defer wh.lock.RUnlock()
+	var payload []byte
+	for _, sub := range wh.subscriptions {
+		if !sub.wants(evt) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			payload, err = json.Marshal(evt)
+			if err != nil {
+				log.Errorf("Failed to marshal %s webhook event: %v", evt.Type, err)
+				return
+			}
+		}
+		go wh.deliver(sub, evt.Type, payload)
+	}
+}
+
+func (wh *Webhooks) deliver(sub *WebhookSubscription, eventType string, payload []byte) {
+	delay := time.Second
+	attempts := *webhookRetries
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = wh.post(sub, eventType, payload)
+		if err == nil {
+			return
+		} else if attempt >= attempts {
+			break
+		}
+		wait := delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+		log.Warnf("Webhook delivery to %s failed (attempt %d/%d), retrying in %s: %v", sub.URL, attempt, attempts, wait, err)
+		time.Sleep(wait)
+		delay *= 2
+	}
+	log.Errorf("Failed to deliver %s event to %s, moving it to the dead-letter table: %v", eventType, sub.URL, err)
+	_, dbErr := wh.db.Exec(
+		`INSERT INTO meow_webhook_dead_letters (url, event, payload, error, timestamp) VALUES ($1, $2, $3, $4, $5)`,
+		sub.URL, eventType, string(payload), fmt.Sprint(err), time.Now().Unix(),
+	)
+	if dbErr != nil {
+		log.Errorf("Failed to store dead-lettered webhook event: %v", dbErr)
+	}
+}
+
+func (wh *Webhooks) post(sub *WebhookSubscription, eventType string, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to prepare request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Meow-Event", eventType)
+	if sub.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(sub.Secret))
+		mac.Write(payload)
+		req.Header.Set("X-Meow-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func newWebhookEvent(eventType string, info types.MessageSource, ts time.Time) *WebhookEvent {
+	return &WebhookEvent{
+		Type:      eventType,
+		Timestamp: ts.Unix(),
+		Chat:      info.Chat,
+		Sender:    info.Sender,
+		IsFromMe:  info.IsFromMe,
+		IsGroup:   info.IsGroup,
+	}
+}
+
+// messageWebhookEvent converts an incoming message to a webhook event.
+func messageWebhookEvent(evt *events.Message) *WebhookEvent {
+	text := evt.Message.GetConversation()
+	if text == "" {
+		text = evt.Message.GetExtendedTextMessage().GetText()
+	}
+	if text == "" {
+		text = evt.Message.GetImageMessage().GetCaption()
+	}
+	if text == "" {
+		text = evt.Message.GetVideoMessage().GetCaption()
+	}
+	whEvt := newWebhookEvent(WebhookMessage, evt.Info.MessageSource, evt.Info.Timestamp)
+	whEvt.Message = &WebhookMessageData{
+		ID:        evt.Info.ID,
+		PushName:  evt.Info.PushName,
+		Type:      evt.Info.Type,
+		MediaType: evt.Info.MediaType,
+		Text:      text,
+		QuotedID:  evt.Message.GetExtendedTextMessage().GetContextInfo().GetStanzaId(),
+	}
+	return whEvt
+}
+
+// receiptWebhookEvent converts a receipt to a webhook event.
+func receiptWebhookEvent(evt *events.Receipt) *WebhookEvent {
+	receiptType := string(evt.Type)
+	if evt.Type == events.ReceiptTypeDelivered {
+		receiptType = "delivered"
+	}
+	whEvt := newWebhookEvent(WebhookReceipt, evt.MessageSource, evt.Timestamp)
+	whEvt.Receipt = &WebhookReceiptData{MessageIDs: evt.MessageIDs, Type: receiptType}
+	return whEvt
+}
+
+// pollVoteWebhookEvent converts a decrypted poll vote to a webhook event.
+func pollVoteWebhookEvent(evt *events.Message, selected [][]byte) *WebhookEvent {
+	options := make([]string, len(selected))
+	for i, hash := range selected {
+		options[i] = hex.EncodeToString(hash)
+	}
+	whEvt := newWebhookEvent(WebhookPollVote, evt.Info.MessageSource, evt.Info.Timestamp)
+	whEvt.PollVote = &WebhookPollVoteData{
+		PollID:          evt.Message.GetPollUpdateMessage().GetPollCreationMessageKey().GetId(),
+		SelectedOptions: options,
+	}
+	return whEvt
+}